@@ -1,13 +1,26 @@
 package comma
 
 import (
+	"fmt"
+	"math"
 	"strconv"
 	"strings"
+
+	"github.com/midbel/comma/eval"
 )
 
+// Aggr computes one value for each column of the rows it receives. Numeric
+// aggregates give eval.Literal values, the others eval.Text values.
+//
+// Empty values are null values: they are skipped by all the aggregates but
+// Count. Columns without values give eval.Null.
+//
+// Merge combines the state of another aggregate of the same kind, computed
+// on the rows that follow the rows given to the receiver, into the receiver.
 type Aggr interface {
 	Aggr([]string) error
-	Result() []float64
+	Merge(Aggr) error
+	Result() []eval.Value
 }
 
 type min struct {
@@ -25,26 +38,42 @@ func (m *min) Aggr(row []string) error {
 	}
 	n := len(m.values)
 	if n == 0 {
-		m.values = make([]float64, len(row))
+		m.values = nans(len(row))
 	} else {
 		if len(row) < n {
 			return ErrRange
 		}
 	}
 	for i, r := range row {
+		if isNull(r) {
+			continue
+		}
 		f, err := parseFloat(r)
 		if err != nil {
 			return err
 		}
-		if n == 0 || f < m.values[i] {
+		if math.IsNaN(m.values[i]) || f < m.values[i] {
 			m.values[i] = f
 		}
 	}
 	return nil
 }
 
-func (m *min) Result() []float64 {
-	return m.values
+func (m *min) Result() []eval.Value {
+	return literals(m.values)
+}
+
+func (m *min) Merge(a Aggr) error {
+	other, ok := a.(*min)
+	if !ok {
+		return ErrMerge
+	}
+	return mergeFloats(&m.values, other.values, func(a, b float64) float64 {
+		if math.IsNaN(a) || b < a {
+			return b
+		}
+		return a
+	})
 }
 
 type max struct {
@@ -62,30 +91,47 @@ func (m *max) Aggr(row []string) error {
 	}
 	n := len(m.values)
 	if n == 0 {
-		m.values = make([]float64, len(row))
+		m.values = nans(len(row))
 	} else {
 		if len(row) < n {
 			return ErrRange
 		}
 	}
 	for i, r := range row {
+		if isNull(r) {
+			continue
+		}
 		f, err := parseFloat(r)
 		if err != nil {
 			return err
 		}
-		if n == 0 || f > m.values[i] {
+		if math.IsNaN(m.values[i]) || f > m.values[i] {
 			m.values[i] = f
 		}
 	}
 	return nil
 }
 
-func (m *max) Result() []float64 {
-	return m.values
+func (m *max) Result() []eval.Value {
+	return literals(m.values)
+}
+
+func (m *max) Merge(a Aggr) error {
+	other, ok := a.(*max)
+	if !ok {
+		return ErrMerge
+	}
+	return mergeFloats(&m.values, other.values, func(a, b float64) float64 {
+		if math.IsNaN(a) || b > a {
+			return b
+		}
+		return a
+	})
 }
 
 type sum struct {
 	values []float64
+	counts []int64
 }
 
 func Sum() Aggr {
@@ -99,29 +145,62 @@ func (s *sum) Aggr(vs []string) error {
 	}
 	if len(s.values) == 0 {
 		s.values = make([]float64, len(vs))
+		s.counts = make([]int64, len(vs))
 	} else {
 		if len(s.values) != len(vs) {
 			return ErrRange
 		}
 	}
 	for i, v := range vs {
+		if isNull(v) {
+			continue
+		}
 		f, err := parseFloat(v)
 		if err != nil {
 			return err
 		}
 		s.values[i] += f
+		s.counts[i]++
 	}
 	return nil
 }
 
-func (s *sum) Result() []float64 {
-	return s.values
+func (s *sum) Result() []eval.Value {
+	vs := literals(s.values)
+	for i := range s.counts {
+		if s.counts[i] == 0 {
+			vs[i] = eval.Null{}
+		}
+	}
+	return vs
+}
+
+func (s *sum) Merge(a Aggr) error {
+	other, ok := a.(*sum)
+	if !ok {
+		return ErrMerge
+	}
+	switch {
+	case len(other.values) == 0:
+	case len(s.values) == 0:
+		s.values = append(s.values, other.values...)
+		s.counts = append(s.counts, other.counts...)
+	case len(s.values) != len(other.values):
+		return ErrRange
+	default:
+		for i := range s.values {
+			s.values[i] += other.values[i]
+			s.counts[i] += other.counts[i]
+		}
+	}
+	return nil
 }
 
 type count struct {
 	values []int64
 }
 
+// Count counts the rows, null values included.
 func Count() Aggr {
 	var c count
 	return &c
@@ -144,44 +223,663 @@ func (c *count) Aggr(vs []string) error {
 	return nil
 }
 
-func (c *count) Result() []float64 {
-	vs := make([]float64, len(c.values))
+func (c *count) Result() []eval.Value {
+	vs := make([]eval.Value, len(c.values))
 	for i := range c.values {
-		vs[i] = float64(c.values[i])
+		vs[i] = eval.Literal(c.values[i])
 	}
 	return vs
 }
 
-type mean struct {
-	aggr  *sum
-	count int
+func (c *count) Merge(a Aggr) error {
+	other, ok := a.(*count)
+	if !ok {
+		return ErrMerge
+	}
+	switch {
+	case len(other.values) == 0:
+	case len(c.values) == 0:
+		c.values = append(c.values, other.values...)
+	case len(c.values) != len(other.values):
+		return ErrRange
+	default:
+		for i := range c.values {
+			c.values[i] += other.values[i]
+		}
+	}
+	return nil
+}
+
+// mergeFloats combines the values of two aggregates column by column.
+func mergeFloats(values *[]float64, others []float64, fn func(float64, float64) float64) error {
+	switch vs := *values; {
+	case len(others) == 0:
+	case len(vs) == 0:
+		*values = append(vs, others...)
+	case len(vs) != len(others):
+		return ErrRange
+	default:
+		for i := range vs {
+			vs[i] = fn(vs[i], others[i])
+		}
+	}
+	return nil
+}
+
+type statistic int
+
+const (
+	statMean statistic = iota
+	statVariance
+	statStddev
+	statSkewness
+	statKurtosis
+)
+
+// moments computes the statistics based on the first moments of the values
+// of each column. The moments are updated incrementally (Welford) to keep
+// the results accurate whatever the number and the magnitude of the values.
+type moments struct {
+	stat   statistic
+	sample bool
+	values []moment
 }
 
 func Mean() Aggr {
-	return &mean{aggr: new(sum)}
+	return &moments{stat: statMean}
+}
+
+// Variance gives the sample variance of the values of each column.
+func Variance() Aggr {
+	return &moments{stat: statVariance, sample: true}
+}
+
+// PopVariance gives the population variance of the values of each column.
+func PopVariance() Aggr {
+	return &moments{stat: statVariance}
+}
+
+// Stddev gives the sample standard deviation of the values of each column.
+func Stddev() Aggr {
+	return &moments{stat: statStddev, sample: true}
+}
+
+// PopStddev gives the population standard deviation of the values of each
+// column.
+func PopStddev() Aggr {
+	return &moments{stat: statStddev}
+}
+
+// Skewness gives the adjusted Fisher-Pearson coefficient of skewness of the
+// values of each column.
+func Skewness() Aggr {
+	return &moments{stat: statSkewness, sample: true}
+}
+
+// PopSkewness gives the population skewness of the values of each column.
+func PopSkewness() Aggr {
+	return &moments{stat: statSkewness}
+}
+
+// Kurtosis gives the sample excess kurtosis of the values of each column.
+func Kurtosis() Aggr {
+	return &moments{stat: statKurtosis, sample: true}
+}
+
+// PopKurtosis gives the population excess kurtosis of the values of each
+// column.
+func PopKurtosis() Aggr {
+	return &moments{stat: statKurtosis}
+}
+
+func (m *moments) Aggr(vs []string) error {
+	if len(vs) == 0 {
+		return nil
+	}
+	if len(m.values) == 0 {
+		m.values = make([]moment, len(vs))
+	} else if len(m.values) != len(vs) {
+		return ErrRange
+	}
+	for i, v := range vs {
+		if isNull(v) {
+			continue
+		}
+		f, err := parseFloat(v)
+		if err != nil {
+			return err
+		}
+		m.values[i].Add(f)
+	}
+	return nil
+}
+
+func (m *moments) Result() []eval.Value {
+	vs := make([]float64, len(m.values))
+	for i, v := range m.values {
+		switch m.stat {
+		case statMean:
+			vs[i] = v.Mean()
+		case statVariance:
+			vs[i] = v.Variance(m.sample)
+		case statStddev:
+			vs[i] = math.Sqrt(v.Variance(m.sample))
+		case statSkewness:
+			vs[i] = v.Skewness(m.sample)
+		case statKurtosis:
+			vs[i] = v.Kurtosis(m.sample)
+		}
+	}
+	return literals(vs)
+}
+
+func (m *moments) Merge(a Aggr) error {
+	other, ok := a.(*moments)
+	if !ok || other.stat != m.stat || other.sample != m.sample {
+		return ErrMerge
+	}
+	switch {
+	case len(other.values) == 0:
+	case len(m.values) == 0:
+		m.values = append(m.values, other.values...)
+	case len(m.values) != len(other.values):
+		return ErrRange
+	default:
+		for i := range m.values {
+			m.values[i].Merge(other.values[i])
+		}
+	}
+	return nil
+}
+
+type moment struct {
+	count float64
+	mean  float64
+	m2    float64
+	m3    float64
+	m4    float64
+}
+
+func (m *moment) Add(f float64) {
+	n := m.count
+	m.count++
+
+	var (
+		delta = f - m.mean
+		dn    = delta / m.count
+		dn2   = dn * dn
+		term  = delta * dn * n
+	)
+	m.mean += dn
+	m.m4 += term*dn2*(m.count*m.count-3*m.count+3) + 6*dn2*m.m2 - 4*dn*m.m3
+	m.m3 += term*dn*(m.count-2) - 3*dn*m.m2
+	m.m2 += term
 }
 
-func (m *mean) Aggr(vs []string) error {
-	err := m.aggr.Aggr(vs)
-	if err == nil {
-		m.count++
+// Merge combines the moments of two sets of values (Chan et al. and Pébay).
+func (m *moment) Merge(other moment) {
+	if other.count == 0 {
+		return
+	}
+	if m.count == 0 {
+		*m = other
+		return
 	}
-	return err
+	var (
+		na    = m.count
+		nb    = other.count
+		n     = na + nb
+		delta = other.mean - m.mean
+		d2    = delta * delta
+		d3    = d2 * delta
+		d4    = d2 * d2
+	)
+	m4 := m.m4 + other.m4 + d4*na*nb*(na*na-na*nb+nb*nb)/(n*n*n)
+	m4 += 6*d2*(na*na*other.m2+nb*nb*m.m2)/(n*n) + 4*delta*(na*other.m3-nb*m.m3)/n
+	m3 := m.m3 + other.m3 + d3*na*nb*(na-nb)/(n*n) + 3*delta*(na*other.m2-nb*m.m2)/n
+	m2 := m.m2 + other.m2 + d2*na*nb/n
+
+	m.count = n
+	m.mean += delta * nb / n
+	m.m2, m.m3, m.m4 = m2, m3, m4
 }
 
-func (m *mean) Result() []float64 {
-	vs := m.aggr.Result()
-	cs := make([]float64, len(vs))
+func (m *moment) Mean() float64 {
 	if m.count == 0 {
-		return cs
+		return math.NaN()
+	}
+	return m.mean
+}
+
+func (m *moment) Variance(sample bool) float64 {
+	if sample {
+		if m.count < 2 {
+			return math.NaN()
+		}
+		return m.m2 / (m.count - 1)
+	}
+	if m.count < 1 {
+		return math.NaN()
+	}
+	return m.m2 / m.count
+}
+
+func (m *moment) Skewness(sample bool) float64 {
+	n := m.count
+	if n < 2 || m.m2 == 0 || (sample && n < 3) {
+		return math.NaN()
 	}
-	c := float64(m.count)
-	for i := range vs {
-		cs[i] = vs[i] / c
+	g := math.Sqrt(n) * m.m3 / math.Pow(m.m2, 1.5)
+	if sample {
+		g *= math.Sqrt(n*(n-1)) / (n - 2)
 	}
-	return cs
+	return g
+}
+
+func (m *moment) Kurtosis(sample bool) float64 {
+	n := m.count
+	if n < 2 || m.m2 == 0 || (sample && n < 4) {
+		return math.NaN()
+	}
+	g := n*m.m4/(m.m2*m.m2) - 3
+	if sample {
+		g = ((n+1)*g + 6) * (n - 1) / ((n - 2) * (n - 3))
+	}
+	return g
+}
+
+// literals gives the results of numeric aggregates. NaN, the result of the
+// columns without (enough) values, gives eval.Null.
+func literals(fs []float64) []eval.Value {
+	vs := make([]eval.Value, len(fs))
+	for i := range fs {
+		if math.IsNaN(fs[i]) {
+			vs[i] = eval.Null{}
+		} else {
+			vs[i] = eval.Literal(fs[i])
+		}
+	}
+	return vs
+}
+
+func nans(n int) []float64 {
+	fs := make([]float64, n)
+	for i := range fs {
+		fs[i] = math.NaN()
+	}
+	return fs
 }
 
 func parseFloat(v string) (float64, error) {
 	return strconv.ParseFloat(strings.TrimSpace(v), 64)
 }
+
+func isNull(v string) bool {
+	return strings.TrimSpace(v) == ""
+}
+
+type first struct {
+	values []string
+}
+
+// First keeps the first non null value of each column.
+func First() Aggr {
+	var f first
+	return &f
+}
+
+func (f *first) Aggr(vs []string) error {
+	switch {
+	case len(vs) == 0:
+	case len(f.values) == 0:
+		f.values = append(f.values, vs...)
+	case len(f.values) != len(vs):
+		return ErrRange
+	default:
+		for i, v := range vs {
+			if isNull(f.values[i]) {
+				f.values[i] = v
+			}
+		}
+	}
+	return nil
+}
+
+func (f *first) Result() []eval.Value {
+	return texts(f.values)
+}
+
+func (f *first) Merge(a Aggr) error {
+	other, ok := a.(*first)
+	if !ok {
+		return ErrMerge
+	}
+	return f.Aggr(other.values)
+}
+
+type last struct {
+	values []string
+}
+
+// Last keeps the last non null value of each column.
+func Last() Aggr {
+	var l last
+	return &l
+}
+
+func (l *last) Aggr(vs []string) error {
+	if len(vs) == 0 {
+		return nil
+	}
+	if len(l.values) == 0 {
+		l.values = make([]string, len(vs))
+	} else if len(l.values) != len(vs) {
+		return ErrRange
+	}
+	for i, v := range vs {
+		if !isNull(v) {
+			l.values[i] = v
+		}
+	}
+	return nil
+}
+
+func (l *last) Result() []eval.Value {
+	return texts(l.values)
+}
+
+func (l *last) Merge(a Aggr) error {
+	other, ok := a.(*last)
+	if !ok {
+		return ErrMerge
+	}
+	return l.Aggr(other.values)
+}
+
+type modeCount struct {
+	count int
+	first int
+}
+
+type mode struct {
+	counts []map[string]*modeCount
+	seen   int
+}
+
+// Mode gives the most frequent value of each column. When several values
+// have the same frequency, the one that appeared first is kept.
+func Mode() Aggr {
+	var m mode
+	return &m
+}
+
+func (m *mode) Aggr(vs []string) error {
+	if len(vs) == 0 {
+		return nil
+	}
+	if err := m.init(len(vs)); err != nil {
+		return err
+	}
+	for i, v := range vs {
+		if isNull(v) {
+			continue
+		}
+		c, ok := m.counts[i][v]
+		if !ok {
+			c = &modeCount{first: m.seen}
+			m.counts[i][v] = c
+		}
+		c.count++
+	}
+	m.seen++
+	return nil
+}
+
+func (m *mode) Merge(a Aggr) error {
+	other, ok := a.(*mode)
+	if !ok {
+		return ErrMerge
+	}
+	if len(other.counts) == 0 {
+		return nil
+	}
+	if err := m.init(len(other.counts)); err != nil {
+		return err
+	}
+	for i := range other.counts {
+		for v, o := range other.counts[i] {
+			c, ok := m.counts[i][v]
+			if !ok {
+				c = &modeCount{first: m.seen + o.first}
+				m.counts[i][v] = c
+			}
+			c.count += o.count
+		}
+	}
+	m.seen += other.seen
+	return nil
+}
+
+func (m *mode) init(n int) error {
+	if len(m.counts) == 0 {
+		m.counts = make([]map[string]*modeCount, n)
+		for i := range m.counts {
+			m.counts[i] = make(map[string]*modeCount)
+		}
+	} else if len(m.counts) != n {
+		return ErrRange
+	}
+	return nil
+}
+
+func (m *mode) Result() []eval.Value {
+	vs := make([]string, len(m.counts))
+	for i := range m.counts {
+		var best *modeCount
+		for v, c := range m.counts[i] {
+			if best == nil || c.count > best.count || (c.count == best.count && c.first < best.first) {
+				best, vs[i] = c, v
+			}
+		}
+	}
+	return texts(vs)
+}
+
+type concat struct {
+	sep    string
+	unique bool
+	values [][]string
+	seen   []map[string]struct{}
+}
+
+// Concat joins the values of each column with sep.
+func Concat(sep string) Aggr {
+	return &concat{sep: sep}
+}
+
+// CollectSet joins the unique values of each column with sep, in the order
+// of their first appearance.
+func CollectSet(sep string) Aggr {
+	return &concat{sep: sep, unique: true}
+}
+
+func (c *concat) Aggr(vs []string) error {
+	if len(vs) == 0 {
+		return nil
+	}
+	if len(c.values) == 0 {
+		c.values = make([][]string, len(vs))
+		c.seen = make([]map[string]struct{}, len(vs))
+		for i := range c.seen {
+			c.seen[i] = make(map[string]struct{})
+		}
+	} else if len(c.values) != len(vs) {
+		return ErrRange
+	}
+	for i, v := range vs {
+		if isNull(v) {
+			continue
+		}
+		if c.unique {
+			if _, ok := c.seen[i][v]; ok {
+				continue
+			}
+			c.seen[i][v] = struct{}{}
+		}
+		c.values[i] = append(c.values[i], v)
+	}
+	return nil
+}
+
+func (c *concat) Result() []eval.Value {
+	vs := make([]eval.Value, len(c.values))
+	for i := range c.values {
+		if len(c.values[i]) == 0 {
+			vs[i] = eval.Null{}
+		} else {
+			vs[i] = eval.Text(strings.Join(c.values[i], c.sep))
+		}
+	}
+	return vs
+}
+
+func (c *concat) Merge(a Aggr) error {
+	other, ok := a.(*concat)
+	if !ok || other.unique != c.unique {
+		return ErrMerge
+	}
+	if len(other.values) == 0 {
+		return nil
+	}
+	if len(c.values) == 0 {
+		c.values = make([][]string, len(other.values))
+		c.seen = make([]map[string]struct{}, len(other.values))
+		for i := range c.seen {
+			c.seen[i] = make(map[string]struct{})
+		}
+	} else if len(c.values) != len(other.values) {
+		return ErrRange
+	}
+	for i, vs := range other.values {
+		for _, v := range vs {
+			if c.unique {
+				if _, ok := c.seen[i][v]; ok {
+					continue
+				}
+				c.seen[i][v] = struct{}{}
+			}
+			c.values[i] = append(c.values[i], v)
+		}
+	}
+	return nil
+}
+
+type extremum struct {
+	key    SortKey
+	kind   string
+	max    bool
+	values []sortValue
+}
+
+// MinOf gives the smallest value of each column, the values being compared
+// according to spec (type[:pattern]) where type is one of the types of the
+// sort keys: string, natural, number, date or datetime. The value is given
+// as it is in the input.
+func MinOf(spec string) (Aggr, error) {
+	return extremumOf(spec, false)
+}
+
+// MaxOf is like MinOf but gives the largest value of each column.
+func MaxOf(spec string) (Aggr, error) {
+	return extremumOf(spec, true)
+}
+
+func extremumOf(spec string, max bool) (Aggr, error) {
+	var (
+		kind    = spec
+		pattern string
+	)
+	if x := strings.Index(spec, ":"); x >= 0 {
+		kind, pattern = spec[:x], spec[x+1:]
+	}
+	k, err := parseSortKey(Selection{}, kind+"::"+pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &extremum{key: k, kind: kind, max: max}, nil
+}
+
+func (e *extremum) Aggr(vs []string) error {
+	if len(vs) == 0 {
+		return nil
+	}
+	n := len(e.values)
+	if n == 0 {
+		e.values = make([]sortValue, len(vs))
+	} else if n != len(vs) {
+		return ErrRange
+	}
+	for i, v := range vs {
+		if isNull(v) {
+			continue
+		}
+		s := e.key.parse(strings.TrimSpace(v))
+		if !s.valid {
+			return fmt.Errorf("%q: invalid %s", v, e.kind)
+		}
+		c := e.key.compare(s, e.values[i])
+		if !e.values[i].valid || (e.max && c > 0) || (!e.max && c < 0) {
+			e.values[i] = s
+		}
+	}
+	return nil
+}
+
+func (e *extremum) Result() []eval.Value {
+	vs := make([]eval.Value, len(e.values))
+	for i := range e.values {
+		if e.values[i].valid {
+			vs[i] = eval.Text(e.values[i].str)
+		} else {
+			vs[i] = eval.Null{}
+		}
+	}
+	return vs
+}
+
+func (e *extremum) Merge(a Aggr) error {
+	other, ok := a.(*extremum)
+	if !ok || other.kind != e.kind || other.max != e.max {
+		return ErrMerge
+	}
+	switch {
+	case len(other.values) == 0:
+	case len(e.values) == 0:
+		e.values = append(e.values, other.values...)
+	case len(e.values) != len(other.values):
+		return ErrRange
+	default:
+		for i, v := range other.values {
+			if !v.valid {
+				continue
+			}
+			c := e.key.compare(v, e.values[i])
+			if !e.values[i].valid || (e.max && c > 0) || (!e.max && c < 0) {
+				e.values[i] = v
+			}
+		}
+	}
+	return nil
+}
+
+func texts(str []string) []eval.Value {
+	vs := make([]eval.Value, len(str))
+	for i := range str {
+		if isNull(str[i]) {
+			vs[i] = eval.Null{}
+		} else {
+			vs[i] = eval.Text(str[i])
+		}
+	}
+	return vs
+}
@@ -0,0 +1,189 @@
+package comma
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/midbel/comma/eval"
+)
+
+func TestMoments(t *testing.T) {
+	data := []struct {
+		Name   string
+		Aggr   Aggr
+		Values []float64
+		Want   float64
+	}{
+		{Name: "mean", Aggr: Mean(), Values: []float64{2, 4, 4, 4, 5, 5, 7, 9}, Want: 5},
+		{Name: "var", Aggr: Variance(), Values: []float64{2, 4, 4, 4, 5, 5, 7, 9}, Want: 32.0 / 7},
+		{Name: "var_pop", Aggr: PopVariance(), Values: []float64{2, 4, 4, 4, 5, 5, 7, 9}, Want: 4},
+		{Name: "stddev_pop", Aggr: PopStddev(), Values: []float64{2, 4, 4, 4, 5, 5, 7, 9}, Want: 2},
+		{Name: "skew_pop", Aggr: PopSkewness(), Values: []float64{2, 4, 4, 4, 5, 5, 7, 9}, Want: 0.65625},
+		{Name: "skew", Aggr: Skewness(), Values: []float64{2, 4, 4, 4, 5, 5, 7, 9}, Want: 0.65625 * math.Sqrt(56) / 6},
+		{Name: "kurt_pop", Aggr: PopKurtosis(), Values: []float64{2, 4, 4, 4, 5, 5, 7, 9}, Want: -0.21875},
+		{Name: "kurt", Aggr: Kurtosis(), Values: []float64{2, 4, 4, 4, 5, 5, 7, 9}, Want: 0.940625},
+		{Name: "mean(large)", Aggr: Mean(), Values: []float64{1e9 + 4, 1e9 + 7, 1e9 + 13, 1e9 + 16}, Want: 1e9 + 10},
+		{Name: "var(large)", Aggr: Variance(), Values: []float64{1e9 + 4, 1e9 + 7, 1e9 + 13, 1e9 + 16}, Want: 30},
+	}
+	for _, d := range data {
+		for _, v := range d.Values {
+			if err := d.Aggr.Aggr([]string{strconv.FormatFloat(v, 'f', -1, 64)}); err != nil {
+				t.Fatalf("%s: unexpected error: %s", d.Name, err)
+			}
+		}
+		got := d.Aggr.Result()
+		if len(got) != 1 || math.Abs(toFloat(got[0])-d.Want) > 1e-9 {
+			t.Errorf("%s: want %f, got %v", d.Name, d.Want, got)
+		}
+	}
+}
+
+func toFloat(v eval.Value) float64 {
+	f, _ := v.(eval.Literal)
+	return float64(f)
+}
+
+func TestTypedAggr(t *testing.T) {
+	maxDate, _ := MaxOf("date")
+	minNatural, _ := MinOf("natural")
+	values := []string{"file10", "file2", "file10", "file3"}
+	data := []struct {
+		Name   string
+		Aggr   Aggr
+		Values []string
+		Want   string
+	}{
+		{Name: "first", Aggr: First(), Values: values, Want: "file10"},
+		{Name: "last", Aggr: Last(), Values: values, Want: "file3"},
+		{Name: "mode", Aggr: Mode(), Values: values, Want: "file10"},
+		{Name: "concat", Aggr: Concat("|"), Values: values, Want: "file10|file2|file10|file3"},
+		{Name: "collect_set", Aggr: CollectSet("|"), Values: values, Want: "file10|file2|file3"},
+		{Name: "min:natural", Aggr: minNatural, Values: values, Want: "file2"},
+		{Name: "max:date", Aggr: maxDate, Values: []string{"2020-01-05", "2021-02-01", "2020/12/31"}, Want: "2021-02-01"},
+	}
+	for _, d := range data {
+		for _, v := range d.Values {
+			if err := d.Aggr.Aggr([]string{v}); err != nil {
+				t.Fatalf("%s: unexpected error: %s", d.Name, err)
+			}
+		}
+		got := d.Aggr.Result()
+		if len(got) != 1 || got[0].String() != d.Want {
+			t.Errorf("%s: want %s, got %v", d.Name, d.Want, got)
+		}
+	}
+	if err := maxDate.Aggr([]string{"foobar"}); err == nil {
+		t.Errorf("max:date: expected error for invalid date")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	maxDate := func() Aggr {
+		a, _ := MaxOf("date")
+		return a
+	}
+	data := []struct {
+		Name string
+		New  func() Aggr
+	}{
+		{Name: "min", New: Min},
+		{Name: "max", New: Max},
+		{Name: "sum", New: Sum},
+		{Name: "count", New: Count},
+		{Name: "mean", New: Mean},
+		{Name: "var", New: Variance},
+		{Name: "skew", New: Skewness},
+		{Name: "kurt", New: Kurtosis},
+		{Name: "median", New: Median},
+		{Name: "distinct", New: Distinct},
+		{Name: "first", New: First},
+		{Name: "last", New: Last},
+		{Name: "mode", New: Mode},
+		{Name: "concat", New: func() Aggr { return Concat(";") }},
+		{Name: "collect_set", New: func() Aggr { return CollectSet(";") }},
+		{Name: "max:date", New: maxDate},
+	}
+	values := []string{"2020-01-05", "2021-02-01", "2020-12-31", "2021-02-01", "2019-07-14", "2020-12-31", "2020-01-05", "2022-03-03"}
+	numbers := []string{"17", "3", "8", "3", "42", "8", "15", "4"}
+	for _, d := range data {
+		vs := numbers
+		switch d.Name {
+		case "first", "last", "mode", "concat", "collect_set", "max:date":
+			vs = values
+		}
+		var (
+			all   = d.New()
+			parts = []Aggr{d.New(), d.New(), d.New()}
+		)
+		for i, v := range vs {
+			all.Aggr([]string{v})
+			parts[(i*len(parts))/len(vs)].Aggr([]string{v})
+		}
+		merged := d.New()
+		for _, p := range parts {
+			if err := merged.Merge(p); err != nil {
+				t.Fatalf("%s: unexpected error: %s", d.Name, err)
+			}
+		}
+		want, got := all.Result(), merged.Result()
+		if len(want) != 1 || len(got) != 1 {
+			t.Errorf("%s: results mismatched: want %v, got %v", d.Name, want, got)
+			continue
+		}
+		if w, ok := want[0].(eval.Literal); ok {
+			if math.Abs(float64(w)-toFloat(got[0])) > 1e-9 {
+				t.Errorf("%s: results mismatched: want %v, got %v", d.Name, want[0], got[0])
+			}
+		} else if want[0].String() != got[0].String() {
+			t.Errorf("%s: results mismatched: want %v, got %v", d.Name, want[0], got[0])
+		}
+	}
+	if err := Sum().Merge(Count()); err == nil {
+		t.Errorf("merging sum with count should fail")
+	}
+}
+
+func TestNulls(t *testing.T) {
+	maxDate, _ := MaxOf("date")
+	data := []struct {
+		Name   string
+		Aggr   Aggr
+		Values []string
+		Want   []eval.Value
+	}{
+		{Name: "min", Aggr: Min(), Values: []string{"", "3", "1", ""}, Want: []eval.Value{eval.Literal(1), eval.Null{}}},
+		{Name: "max", Aggr: Max(), Values: []string{"", "3", "1", ""}, Want: []eval.Value{eval.Literal(3), eval.Null{}}},
+		{Name: "sum", Aggr: Sum(), Values: []string{"", "3", "1", ""}, Want: []eval.Value{eval.Literal(4), eval.Null{}}},
+		{Name: "count", Aggr: Count(), Values: []string{"", "3", "1", ""}, Want: []eval.Value{eval.Literal(4), eval.Literal(4)}},
+		{Name: "mean", Aggr: Mean(), Values: []string{"", "3", "1", ""}, Want: []eval.Value{eval.Literal(2), eval.Null{}}},
+		{Name: "median", Aggr: Median(), Values: []string{"", "3", "1", ""}, Want: []eval.Value{eval.Literal(2), eval.Null{}}},
+		{Name: "distinct", Aggr: Distinct(), Values: []string{"", "3", "3", ""}, Want: []eval.Value{eval.Literal(1), eval.Literal(0)}},
+		{Name: "first", Aggr: First(), Values: []string{"", "a", "b", ""}, Want: []eval.Value{eval.Text("a"), eval.Null{}}},
+		{Name: "last", Aggr: Last(), Values: []string{"a", "", "b", ""}, Want: []eval.Value{eval.Text("b"), eval.Null{}}},
+		{Name: "mode", Aggr: Mode(), Values: []string{"", "a", "", ""}, Want: []eval.Value{eval.Text("a"), eval.Null{}}},
+		{Name: "concat", Aggr: Concat("|"), Values: []string{"a", "", "b", ""}, Want: []eval.Value{eval.Text("a|b"), eval.Null{}}},
+		{Name: "max:date", Aggr: maxDate, Values: []string{"", "2020-01-05", "2021-02-01", ""}, Want: []eval.Value{eval.Text("2021-02-01"), eval.Null{}}},
+	}
+	for _, d := range data {
+		// the first column has values, the second one only null values
+		for i := 0; i < len(d.Values); i += 2 {
+			if err := d.Aggr.Aggr([]string{d.Values[i], ""}); err != nil {
+				t.Fatalf("%s: unexpected error: %s", d.Name, err)
+			}
+			if err := d.Aggr.Aggr([]string{d.Values[i+1], " "}); err != nil {
+				t.Fatalf("%s: unexpected error: %s", d.Name, err)
+			}
+		}
+		got := d.Aggr.Result()
+		if len(got) != len(d.Want) {
+			t.Errorf("%s: want %v, got %v", d.Name, d.Want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != d.Want[i] {
+				t.Errorf("%s: column %d: want %#v, got %#v", d.Name, i+1, d.Want[i], got[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/midbel/cli"
+	"github.com/midbel/comma"
+	"github.com/midbel/timefmt"
+)
+
+const maxDistinct = 1 << 16
+
+var describeHeaders = []string{
+	"column",
+	"type",
+	"count",
+	"nulls",
+	"distinct",
+	"min",
+	"max",
+	"mean",
+	"stddev",
+	"minlen",
+	"maxlen",
+	"top",
+}
+
+var datePatterns = []string{
+	"%Y-%m-%d",
+	"%Y/%m/%d",
+	"%Y-%m-%d %H:%M:%S",
+	"%Y-%m-%dT%H:%M:%S",
+}
+
+func runDescribe(cmd *cli.Command, args []string) (err error) {
+	o := Options{
+		Separator: Comma(','),
+		Width:     DefaultWidth,
+	}
+	cmd.Flag.Var(&o.Separator, "separator", "separator")
+	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
+	o.registerInput(&cmd.Flag, true)
+	o.registerOutput(&cmd.Flag)
+	cmd.Flag.IntVar(&o.Limit, "top", 3, "number of most frequent values to report")
+
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	r, err := o.OpenFile(cmd.Flag.Arg(0), "", nil)
+	if err != nil {
+		return err
+	}
+	defer o.Close(r)
+
+	var ps []*profile
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for i, v := range r.Nulls().Mask(row) {
+			if i >= len(ps) {
+				ps = append(ps, newProfile(i, r.Headers()))
+			}
+			if err := ps[i].Update(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeDump(dump, &err)
+	if err := dump.Headers(describeHeaders); err != nil {
+		return err
+	}
+	for _, p := range ps {
+		if err := dump.Dump(p.Report(o.Limit)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// profile collects the statistics of one column of a file.
+type profile struct {
+	Name string
+
+	count  int
+	nulls  int
+	ints   int
+	floats int
+	bools  int
+	dates  int
+
+	minlen int
+	maxlen int
+	first  string
+	last   string
+
+	min      comma.Aggr
+	max      comma.Aggr
+	mean     comma.Aggr
+	stddev   comma.Aggr
+	distinct comma.Aggr
+
+	// frequencies of the first maxDistinct values, used to report the most
+	// frequent ones
+	freq map[string]int
+}
+
+func newProfile(i int, headers []string) *profile {
+	name := strconv.Itoa(i + 1)
+	if i < len(headers) {
+		name = headers[i]
+	}
+	return &profile{
+		Name:     name,
+		min:      comma.Min(),
+		max:      comma.Max(),
+		mean:     comma.Mean(),
+		stddev:   comma.Stddev(),
+		distinct: comma.Distinct(),
+		freq:     make(map[string]int),
+	}
+}
+
+func (p *profile) Update(v string) error {
+	p.count++
+	v = strings.TrimSpace(v)
+	if v == "" {
+		p.nulls++
+		return nil
+	}
+	if z := utf8.RuneCountInString(v); p.count-p.nulls == 1 {
+		p.minlen, p.maxlen, p.first, p.last = z, z, v, v
+	} else {
+		if z < p.minlen {
+			p.minlen = z
+		}
+		if z > p.maxlen {
+			p.maxlen = z
+		}
+		if v < p.first {
+			p.first = v
+		}
+		if v > p.last {
+			p.last = v
+		}
+	}
+	if _, ok := p.freq[v]; ok || len(p.freq) < maxDistinct {
+		p.freq[v]++
+	}
+	if err := p.distinct.Aggr([]string{v}); err != nil {
+		return err
+	}
+
+	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+		p.ints++
+	}
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		p.floats++
+		vs := []string{v}
+		for _, a := range []comma.Aggr{p.min, p.max, p.mean, p.stddev} {
+			if err := a.Aggr(vs); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := strconv.ParseBool(v); err == nil {
+		p.bools++
+	}
+	for _, pat := range datePatterns {
+		if _, err := timefmt.Parse(v, pat); err == nil {
+			p.dates++
+			break
+		}
+	}
+	return nil
+}
+
+func (p *profile) Type() string {
+	n := p.count - p.nulls
+	switch {
+	case n == 0:
+		return "string"
+	case p.ints == n:
+		return "int"
+	case p.floats == n:
+		return "float"
+	case p.bools == n:
+		return "bool"
+	case p.dates == n:
+		return "date"
+	default:
+		return "string"
+	}
+}
+
+func (p *profile) Report(top int) []string {
+	typ := p.Type()
+	var distinct int
+	if vs := p.distinct.Result(); len(vs) > 0 {
+		distinct = int(toFloat(vs[0]))
+	}
+	row := []string{
+		p.Name,
+		typ,
+		strconv.Itoa(p.count),
+		strconv.Itoa(p.nulls),
+		strconv.Itoa(distinct),
+	}
+	switch typ {
+	case "int", "float":
+		row = append(row, formatResult(p.min), formatResult(p.max), formatResult(p.mean), formatResult(p.stddev))
+	default:
+		row = append(row, p.first, p.last, "", "")
+	}
+	row = append(row, strconv.Itoa(p.minlen), strconv.Itoa(p.maxlen), p.Top(top))
+	return row
+}
+
+// Top gives the n most frequent values of the column with their count.
+func (p *profile) Top(n int) string {
+	type pair struct {
+		Value string
+		Count int
+	}
+	ps := make([]pair, 0, len(p.freq))
+	for v, c := range p.freq {
+		ps = append(ps, pair{Value: v, Count: c})
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		if ps[i].Count == ps[j].Count {
+			return ps[i].Value < ps[j].Value
+		}
+		return ps[i].Count > ps[j].Count
+	})
+	if n > 0 && len(ps) > n {
+		ps = ps[:n]
+	}
+	vs := make([]string, len(ps))
+	for i, p := range ps {
+		vs[i] = fmt.Sprintf("%s(%d)", p.Value, p.Count)
+	}
+	return strings.Join(vs, ";")
+}
+
+func formatResult(a comma.Aggr) string {
+	vs := a.Result()
+	if len(vs) == 0 || math.IsNaN(toFloat(vs[0])) {
+		return ""
+	}
+	return formatValue(vs[0])
+}
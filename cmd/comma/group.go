@@ -0,0 +1,527 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/midbel/comma"
+	"github.com/midbel/comma/eval"
+)
+
+const groupChunkSize = 4096
+
+const orderUsage = "order of the groups: key, value[:N] (N-th aggregate) or first (appearance)"
+
+const (
+	orderKey   = "key"
+	orderValue = "value"
+	orderFirst = "first"
+)
+
+// Order tells in which order the groups are written.
+type Order struct {
+	By      string
+	Column  int
+	Reverse bool
+	Top     int
+}
+
+func (o *Order) Set(str string) error {
+	by, col := strings.ToLower(str), 0
+	if x := strings.Index(by, ":"); x >= 0 {
+		n, err := strconv.Atoi(by[x+1:])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("%s: invalid column", str)
+		}
+		by, col = by[:x], n-1
+	}
+	switch by {
+	case orderKey, orderFirst:
+		if col > 0 {
+			return fmt.Errorf("%s: unexpected column", str)
+		}
+	case orderValue:
+	default:
+		return fmt.Errorf("unknown order %s", str)
+	}
+	o.By, o.Column = by, col
+	return nil
+}
+
+func (o *Order) String() string {
+	if o.By == orderValue && o.Column > 0 {
+		return fmt.Sprintf("%s:%d", o.By, o.Column+1)
+	}
+	return o.By
+}
+
+type Row struct {
+	Keys []string
+	Hash string
+
+	Data []Aggr
+
+	// rolled marks the keys replaced by a placeholder in the subtotal rows.
+	rolled []bool
+}
+
+func (r *Row) Update(row []string) error {
+	for _, d := range r.Data {
+		if err := d.Update(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// value gives the n-th result of the aggregates of the row.
+func (r *Row) value(n int) eval.Value {
+	for _, d := range r.Data {
+		vs := d.Result()
+		if n < len(vs) {
+			return vs[n]
+		}
+		n -= len(vs)
+	}
+	return nil
+}
+
+func (r *Row) results() []eval.Value {
+	var vs []eval.Value
+	for _, d := range r.Data {
+		vs = append(vs, d.Result()...)
+	}
+	return vs
+}
+
+// Having keeps the groups matching a filter expression evaluated after the
+// aggregation. The expression references the keys and the results of the
+// aggregates by their position or by their name (eg: $count > 100).
+type Having struct {
+	Expr string
+
+	filter *comma.Filter
+}
+
+// Match tells if the values of a group match the expression. names is only
+// called once to give the names that the expression can reference.
+func (h *Having) Match(vs []eval.Value, names func() []string) (bool, error) {
+	if h.Expr == "" {
+		return true, nil
+	}
+	if h.filter == nil {
+		f, err := comma.ParseFilterWithHeaders(h.Expr, names())
+		if err != nil {
+			return false, fmt.Errorf("having: %w", err)
+		}
+		h.filter = f
+	}
+	row := make([]string, len(vs))
+	for i, v := range vs {
+		row[i] = v.String()
+	}
+	ok, err := h.filter.MatchRow(row)
+	if err != nil {
+		return false, fmt.Errorf("having: %w", err)
+	}
+	return ok, nil
+}
+
+// groupNames gives the names of the keys and of the results of the aggregates
+// of r. Without headers, the results are named after their aggregate.
+func groupNames(r *Row, sel []comma.Selection, ops, headers []string) []string {
+	names, _ := selectKeys(sel, headers)
+	if len(names) != len(r.Keys) {
+		names = make([]string, len(r.Keys))
+	}
+	hs := aggrHeaders(ops, headers)
+	if len(hs) == len(r.results()) {
+		return append(names, hs...)
+	}
+	for i, d := range r.Data {
+		op := "count"
+		if len(ops) > 0 {
+			op = aggrName(ops[i*2])
+		}
+		for range d.Result() {
+			names = append(names, op)
+		}
+	}
+	return names
+}
+
+// Groups keeps the rows sharing the same keys together with their
+// aggregates. The groups are kept in the order of their first appearance.
+type Groups struct {
+	Ops     []string
+	Sel     []comma.Selection
+	Headers []string
+	// Nulls are masked in the values given to the aggregates, not in the keys.
+	Nulls comma.Nulls
+	// Sets gives the grouping sets of the subtotals (eg: rollupSets, cubeSets)
+	// of n keys. A grouping set tells, for each key, if it is kept or rolled
+	// up and replaced by Placeholder.
+	Sets        func(n int) [][]bool
+	Placeholder string
+
+	index  map[string]*Row
+	rows   []*Row
+	totals []*Row
+	sets   [][]bool
+}
+
+// Upsert updates the group of the keys of vs and, when Sets is given, the
+// subtotals of this group: each row is given to its subtotals in the order of
+// the input like to its group.
+func (g *Groups) Upsert(vs []string) error {
+	ks, hash := selectKeys(g.Sel, vs)
+	if len(ks) == 0 {
+		return nil
+	}
+	r, err := g.upsertKeys(ks, hash, nil)
+	if err != nil {
+		return err
+	}
+	vs = g.Nulls.Mask(vs)
+	if err := r.Update(vs); err != nil {
+		return err
+	}
+	if g.Sets == nil {
+		return nil
+	}
+	if g.sets == nil {
+		g.sets = g.Sets(len(ks))
+	}
+	for _, set := range g.sets {
+		s, err := g.upsertTotal(ks, set)
+		if err != nil {
+			return err
+		}
+		if err := s.Update(vs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsertTotal gives the subtotal of the grouping set of the keys ks.
+func (g *Groups) upsertTotal(ks []string, set []bool) (*Row, error) {
+	var (
+		keys   = make([]string, len(ks))
+		rolled = make([]bool, len(ks))
+		mask   = make([]byte, len(ks))
+	)
+	for i := range keys {
+		keys[i], mask[i] = ks[i], '1'
+		if i >= len(set) || !set[i] {
+			keys[i], rolled[i], mask[i] = g.Placeholder, true, '0'
+		}
+	}
+	return g.upsertKeys(keys, strings.Join(keys, "\x00")+"\x01"+string(mask), rolled)
+}
+
+// Merge adds the groups of other to the groups of g. The aggregates of other
+// are merged into the aggregates of the groups with the same keys.
+func (g *Groups) Merge(other *Groups) error {
+	for _, o := range append(append([]*Row{}, other.rows...), other.totals...) {
+		r, err := g.upsertKeys(o.Keys, o.Hash, o.rolled)
+		if err != nil {
+			return err
+		}
+		for i := range r.Data {
+			if err := r.Data[i].Merge(o.Data[i].Aggr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Rows gives the groups in the given order. The number of groups is not
+// limited by order.Top: it is up to the caller once the groups are filtered.
+// In the order of appearance, the subtotals come after the groups.
+func (g *Groups) Rows(order Order) []*Row {
+	rs := make([]*Row, 0, len(g.rows)+len(g.totals))
+	rs = append(append(rs, g.rows...), g.totals...)
+
+	var compare func(i, j int) int
+	switch order.By {
+	case orderFirst:
+		compare = func(i, j int) int { return 0 }
+		if order.Reverse {
+			for i, j := 0, len(rs)-1; i < j; i, j = i+1, j-1 {
+				rs[i], rs[j] = rs[j], rs[i]
+			}
+		}
+	case orderValue:
+		vs := make(map[*Row]eval.Value, len(rs))
+		for _, r := range rs {
+			vs[r] = r.value(order.Column)
+		}
+		compare = func(i, j int) int {
+			return compareValues(vs[rs[i]], vs[rs[j]])
+		}
+	default:
+		compare = func(i, j int) int {
+			return compareRows(rs[i], rs[j])
+		}
+	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		c := compare(i, j)
+		if order.Reverse {
+			c = -c
+		}
+		return c < 0
+	})
+	return rs
+}
+
+// rollupSets gives the grouping sets of each prefix of n keys, from the longest
+// to the empty one (grand total).
+func rollupSets(n int) [][]bool {
+	var sets [][]bool
+	for k := n - 1; k >= 0; k-- {
+		set := make([]bool, n)
+		for i := 0; i < k; i++ {
+			set[i] = true
+		}
+		sets = append(sets, set)
+	}
+	return sets
+}
+
+// cubeSets gives the grouping sets of each subset of n keys, except the set of
+// all the keys.
+func cubeSets(n int) [][]bool {
+	var sets [][]bool
+	for m := (1 << n) - 2; m >= 0; m-- {
+		set := make([]bool, n)
+		for i := range set {
+			set[i] = m&(1<<(n-1-i)) != 0
+		}
+		sets = append(sets, set)
+	}
+	return sets
+}
+
+// upsertKeys gives the group of the keys ks, creating it if needed. rolled is
+// only set for the subtotals.
+func (g *Groups) upsertKeys(ks []string, hash string, rolled []bool) (*Row, error) {
+	if g.index == nil {
+		g.index = make(map[string]*Row)
+	}
+	if r, ok := g.index[hash]; ok {
+		return r, nil
+	}
+	var (
+		as  []Aggr
+		err error
+	)
+	if len(g.Ops) == 0 {
+		a := Aggr{
+			sel:    g.Sel,
+			single: true,
+			Aggr:   comma.Count(),
+		}
+		as = []Aggr{a}
+	} else {
+		as, err = parseAggr(g.Ops, g.Headers)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := Row{
+		Keys:   ks,
+		Hash:   hash,
+		Data:   as,
+		rolled: rolled,
+	}
+	g.index[hash] = &r
+	if rolled != nil {
+		g.totals = append(g.totals, &r)
+	} else {
+		g.rows = append(g.rows, &r)
+	}
+	return &r, nil
+}
+
+// groupRows groups the rows of r with the Groups given by create. With more
+// than one worker, chunks of rows are grouped concurrently and the partial
+// groups are merged in the order of the chunks - the aggregates depending on
+// the order of the rows (first, last, concat...) give then the same results
+// as with a single worker.
+func groupRows(r *comma.Reader, workers int, create func() *Groups) (*Groups, error) {
+	if workers <= 1 {
+		data := create()
+		for {
+			row, err := r.Next()
+			if err == io.EOF {
+				return data, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := data.Upsert(row); err != nil {
+				return nil, err
+			}
+		}
+	}
+	type chunk struct {
+		index int
+		rows  [][]string
+	}
+	type partial struct {
+		index  int
+		groups *Groups
+		err    error
+	}
+	var (
+		chunks   = make(chan chunk, workers)
+		partials = make(chan partial, workers)
+		merged   = make(chan error, 1)
+		quit     = make(chan struct{})
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				var (
+					data = create()
+					err  error
+				)
+				for _, row := range c.rows {
+					if err = data.Upsert(row); err != nil {
+						break
+					}
+				}
+				partials <- partial{index: c.index, groups: data, err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(partials)
+	}()
+
+	data := create()
+	go func() {
+		var (
+			next    int
+			pending = make(map[int]*Groups)
+			err     error
+		)
+		for p := range partials {
+			if err != nil {
+				continue
+			}
+			if err = p.err; err != nil {
+				close(quit)
+				continue
+			}
+			pending[p.index] = p.groups
+			for g, ok := pending[next]; ok; g, ok = pending[next] {
+				delete(pending, next)
+				next++
+				if err = data.Merge(g); err != nil {
+					close(quit)
+					break
+				}
+			}
+		}
+		merged <- err
+	}()
+
+	var err error
+	for i := 0; err == nil; i++ {
+		rows := make([][]string, 0, groupChunkSize)
+		for len(rows) < groupChunkSize {
+			var row []string
+			if row, err = r.Next(); err != nil {
+				break
+			}
+			rows = append(rows, row)
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		select {
+		case chunks <- chunk{index: i, rows: rows}:
+		case <-quit:
+			err = io.EOF
+		}
+	}
+	close(chunks)
+	if e := <-merged; e != nil {
+		return nil, e
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+func compareKeys(k1, k2 []string) int {
+	for i := 0; i < len(k1) && i < len(k2); i++ {
+		c := strings.Compare(k1[i], k2[i])
+		if c != 0 {
+			return c
+		}
+	}
+	return len(k1) - len(k2)
+}
+
+// compareRows compares the keys of two rows. Keys rolled up in subtotal rows
+// come after the other values: subtotals follow the groups they summarize.
+func compareRows(r1, r2 *Row) int {
+	if r1.rolled == nil && r2.rolled == nil {
+		return compareKeys(r1.Keys, r2.Keys)
+	}
+	for i := 0; i < len(r1.Keys) && i < len(r2.Keys); i++ {
+		x1 := i < len(r1.rolled) && r1.rolled[i]
+		x2 := i < len(r2.rolled) && r2.rolled[i]
+		switch {
+		case x1 && x2:
+			continue
+		case x1:
+			return 1
+		case x2:
+			return -1
+		}
+		if c := strings.Compare(r1.Keys[i], r2.Keys[i]); c != 0 {
+			return c
+		}
+	}
+	return len(r1.Keys) - len(r2.Keys)
+}
+
+// compareValues compares numbers by their value and the other values by their
+// string representation. Missing values come first.
+func compareValues(v1, v2 eval.Value) int {
+	switch {
+	case v1 == nil && v2 == nil:
+		return 0
+	case v1 == nil:
+		return -1
+	case v2 == nil:
+		return 1
+	}
+	f1, ok1 := v1.(eval.Literal)
+	f2, ok2 := v2.(eval.Literal)
+	if ok1 && ok2 {
+		switch {
+		case f1 < f2:
+			return -1
+		case f1 > f2:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return strings.Compare(v1.String(), v2.String())
+}
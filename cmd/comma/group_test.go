@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGroupsRollup(t *testing.T) {
+	var (
+		headers = []string{"k1", "k2", "v"}
+		rows    = [][]string{
+			{"A", "x", "1"},
+			{"B", "x", "2"},
+			{"A", "y", "3"},
+		}
+		want = []string{
+			"A,x,1,1",
+			"A,y,3,3",
+			"A,*,3,1;3",
+			"B,x,2,2",
+			"B,*,2,2",
+			"*,*,3,1;2;3",
+		}
+	)
+	sel, err := parseKeys("k1,k2", headers)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	create := func() *Groups {
+		return &Groups{
+			Ops:         []string{"last", "v", "concat", "v"},
+			Sel:         sel,
+			Headers:     headers,
+			Sets:        rollupSets,
+			Placeholder: "*",
+		}
+	}
+	// the rows are grouped at once then in two parts merged in order like
+	// with several workers.
+	for _, parts := range [][][][]string{{rows}, {rows[:1], rows[1:]}} {
+		data := create()
+		for _, rs := range parts {
+			g := create()
+			for _, r := range rs {
+				if err := g.Upsert(r); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+			if err := data.Merge(g); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+		got := groupLines(data.Rows(Order{By: orderKey}))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d part(s): groups mismatched: want %q, got %q", len(parts), want, got)
+		}
+	}
+}
+
+func TestGroupsCube(t *testing.T) {
+	var (
+		headers = []string{"k1", "k2"}
+		rows    = [][]string{
+			{"A", "x"},
+			{"B", "x"},
+			{"A", "y"},
+		}
+		want = []string{
+			"A,x,1.00",
+			"B,x,1.00",
+			"A,y,1.00",
+			"A,*,2.00",
+			"*,x,2.00",
+			"*,*,3.00",
+			"B,*,1.00",
+			"*,y,1.00",
+		}
+	)
+	sel, err := parseKeys("k1,k2", headers)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data := Groups{
+		Ops:         []string{"count", "k1"},
+		Sel:         sel,
+		Headers:     headers,
+		Sets:        cubeSets,
+		Placeholder: "*",
+	}
+	for _, r := range rows {
+		if err := data.Upsert(r); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	got := groupLines(data.Rows(Order{By: orderFirst}))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("groups mismatched: want %q, got %q", want, got)
+	}
+}
+
+func groupLines(rs []*Row) []string {
+	var lines []string
+	for _, r := range rs {
+		row := append([]string{}, r.Keys...)
+		for _, v := range r.results() {
+			row = append(row, formatValue(v))
+		}
+		lines = append(lines, strings.Join(row, ","))
+	}
+	return lines
+}
@@ -3,16 +3,20 @@ package main
 import (
 	"encoding/csv"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 
 	"github.com/midbel/cli"
 	"github.com/midbel/comma"
-	"github.com/midbel/linewriter"
+	"github.com/midbel/comma/eval"
 )
 
 var commands = []*cli.Command{
@@ -22,8 +26,8 @@ var commands = []*cli.Command{
 		Run:   runSelect,
 	},
 	{
-		Usage: "describe <file>",
-		Short: "",
+		Usage: "describe [-header] [-table] [-top] <file>",
+		Short: "describe print a profile of each column of a file",
 		Run:   runDescribe,
 	},
 	{
@@ -38,16 +42,32 @@ var commands = []*cli.Command{
 		Run:   runFormat,
 	},
 	{
-		Usage: "group [-table] [-tag] [-file] <selection> [<operation>...]",
+		Usage: "group [-table] [-tag] [-file] [-order] [-reverse] [-top] [-having] [-rollup] [-cube] [-placeholder] [-workers] <selection> [<operation>...]",
 		Short: "",
 		Run:   runGroup,
 	},
 	{
-		Usage: "frequency [-table] [-tag] [-file] <selection>",
+		Usage: "frequency [-table] [-tag] [-file] [-order] [-reverse] [-top] [-having] <selection>",
 		Alias: []string{"freq"},
 		Short: "",
 		Run:   runFrequency,
 	},
+	{
+		Usage: "pivot [-table] [-tag] [-file] [-order] [-fill] <row-keys> <column-key> <operation> <value>",
+		Short: "pivot spread the result of an aggregate over one column per value of the column key",
+		Run:   runPivot,
+	},
+	{
+		Usage: "melt [-table] [-tag] [-file] [-key] [-value] [-skip-empty] <id-columns> <value-columns>",
+		Alias: []string{"unpivot"},
+		Short: "melt turn each value column of a row into a row of key/value",
+		Run:   runMelt,
+	},
+	{
+		Usage: "window [-table] [-tag] [-file] [-order] [-spec] <partition> <function> [<column>]...",
+		Short: "window add columns computed over the ordered rows of each partition",
+		Run:   runWindow,
+	},
 	{
 		Usage: "transpose [-table] [-file]",
 		Short: "transpose switch the columns and the rows",
@@ -59,12 +79,22 @@ var commands = []*cli.Command{
 		Run:   runCat,
 	},
 	{
-		Usage: "sort [-table] [-width] [-file] <selection>",
-		Short: "",
+		Usage: "sort [-table] [-width] [-file] [-memory] [-tmpdir] <selection> [<type:order>...]",
+		Short: "sort order the rows of a file according to one or multiple keys",
 		Run:   runSort,
 	},
 	{
-		Usage: "split [-datadir] [-prefix] [-file] <selection> <expression>",
+		Usage: "join [-mode] [-memory] [-tmpdir] [-table] -left <file> -right <file> -on <selection=selection>",
+		Short: "join combine the rows of two files having the same values for the given keys",
+		Run:   runJoin,
+	},
+	{
+		Usage: "cross [-table] [-width] -left <file> -right <file>",
+		Short: "cross produce the cartesian product of the rows of two files",
+		Run:   runCross,
+	},
+	{
+		Usage: "split [-datadir] [-prefix] [-compress] [-file] <selection> <expression>",
 		Short: "split creates multiple files from the given file according to a criteria",
 		Run:   runSplit,
 	},
@@ -118,13 +148,50 @@ func (c *Comma) String() string {
 	return fmt.Sprintf("%c", *c)
 }
 
+type Memory int64
+
+func (m *Memory) Set(v string) error {
+	str := strings.ToUpper(strings.TrimSpace(v))
+	mul := int64(1)
+	for _, u := range []struct {
+		Suffix string
+		Mul    int64
+	}{
+		{Suffix: "GB", Mul: 1 << 30},
+		{Suffix: "MB", Mul: 1 << 20},
+		{Suffix: "KB", Mul: 1 << 10},
+		{Suffix: "B", Mul: 1},
+	} {
+		if strings.HasSuffix(str, u.Suffix) {
+			str, mul = strings.TrimSuffix(str, u.Suffix), u.Mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(str), 10, 64)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid memory size provided %s", v)
+	}
+	*m = Memory(n * mul)
+	return nil
+}
+
+func (m *Memory) String() string {
+	return fmt.Sprintf("%dB", *m)
+}
+
 type Options struct {
 	File      string
 	Separator Comma
+	Header    bool
+	Input     string
+	Errors    string
+	Rejects   string
+	Nulls     string
 
-	Limit int
-	Width int
-	Table bool
+	Limit  int
+	Width  int
+	Table  bool
+	Output string
 
 	Append  bool
 	Prefix  string
@@ -132,43 +199,367 @@ type Options struct {
 	Tag     string
 }
 
+// registerInput registers the flags telling how the input is read. rejects is
+// false for the commands reading two inputs: the rows rejected from both would
+// go to the same file.
+func (o *Options) registerInput(set *flag.FlagSet, rejects bool) {
+	set.BoolVar(&o.Header, "header", false, "first row gives the names of the columns")
+	set.StringVar(&o.Input, "input", "", inputUsage)
+	set.StringVar(&o.Errors, "errors", "fail", "policy for bad rows (fail, skip, collect)")
+	set.StringVar(&o.Nulls, "nulls", "", "comma separated list of the values read as null values (eg: NA,NULL,-)")
+	if rejects {
+		set.StringVar(&o.Rejects, "rejects", "", "file where rows rejected by the collect policy are written")
+	}
+}
+
+// registerOutput registers the flags selecting the output format.
+func (o *Options) registerOutput(set *flag.FlagSet) {
+	set.BoolVar(&o.Table, "table", false, "print data in table format")
+	set.StringVar(&o.Output, "output", "", outputUsage)
+}
+
 func (o Options) Open(cols string, specs []string) (*comma.Reader, error) {
-	opts := []comma.Option{
-		comma.WithSeparator(o.Separator.Rune()),
-		comma.WithSelection(cols),
-		comma.WithFormatters(specs),
+	return o.OpenFile(o.File, cols, specs)
+}
+
+func (o Options) OpenFile(file, cols string, specs []string) (*comma.Reader, error) {
+	var opts []comma.Option
+	if c := o.Separator.Rune(); c != ',' {
+		opts = append(opts, comma.WithSeparator(c))
 	}
-	var (
-		r   *comma.Reader
-		err error
-	)
-	if o.File == "" || o.File == "-" {
-		r, err = comma.NewReader(os.Stdin, opts...)
+	if o.Input != "" {
+		opt, err := parseInput(o.Input)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	opts = append(opts, comma.WithSelection(cols), comma.WithFormatters(specs))
+	if o.Nulls != "" {
+		opts = append(opts, comma.WithNulls(strings.Split(o.Nulls, ",")...))
+	}
+	if o.Header {
+		opts = append(opts, comma.WithHeader())
+	}
+	policy, err := comma.ParseErrorPolicy(o.Errors)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, comma.WithErrorPolicy(policy))
+	if o.Rejects != "" {
+		if policy != comma.PolicyCollect {
+			return nil, fmt.Errorf("rejects: error policy should be collect")
+		}
+		w, e := os.Create(o.Rejects)
+		if e != nil {
+			return nil, e
+		}
+		defer func() {
+			if err != nil {
+				w.Close()
+			}
+		}()
+		opts = append(opts, comma.WithRejects(w))
+	}
+	var r *comma.Reader
+	if file == "" || file == "-" {
+		var z io.ReadCloser
+		if z, err = comma.Decompress(os.Stdin); err != nil {
+			return nil, err
+		}
+		r, err = comma.NewReader(z, opts...)
 	} else {
-		r, err = comma.Open(o.File, opts...)
+		r, err = comma.Open(file, opts...)
 	}
 	return r, err
 }
 
-var ErrImplemented = errors.New("not yet implemented")
+// Close closes the reader and reports on stderr the number of rows rejected
+// by the skip and collect error policies.
+func (o Options) Close(r *comma.Reader) error {
+	err := r.Close()
+	rs := r.Rejected()
+	if len(rs) == 0 {
+		return err
+	}
+	ks := make([]string, 0, len(rs))
+	for k := range rs {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	for _, k := range ks {
+		fmt.Fprintf(os.Stderr, "%s: %d row(s) rejected\n", k, rs[k])
+	}
+	return err
+}
+
+const inputUsage = "input format (csv, tsv, json, ndjson, fixed:<width>,...). With json and ndjson, the columns are the keys of the first object: objects with other keys are bad rows"
 
-func runSort(cmd *cli.Command, args []string) error {
-	return ErrImplemented
+// parseInput gives the option selecting the format of the input. Widths of
+// fixed-width input are given after the format: fixed:10,5,20.
+func parseInput(str string) (comma.Option, error) {
+	format, spec := str, ""
+	if x := strings.Index(str, ":"); x >= 0 {
+		format, spec = str[:x], str[x+1:]
+	}
+	if format != comma.InputFixed {
+		if spec != "" {
+			return nil, fmt.Errorf("%s: unexpected %s", str, spec)
+		}
+		return comma.WithInput(format), nil
+	}
+	var widths []int
+	for _, v := range strings.Split(spec, ",") {
+		w, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid width %s", str, v)
+		}
+		widths = append(widths, w)
+	}
+	return comma.WithWidths(widths), nil
 }
 
-func runJoin(cmd *cli.Command, args []string) error {
-	return ErrImplemented
+var ErrImplemented = errors.New("not yet implemented")
+
+func runSort(cmd *cli.Command, args []string) (err error) {
+	o := Options{
+		Separator: Comma(','),
+		Width:     DefaultWidth,
+	}
+	memory := Memory(comma.DefaultMemoryLimit)
+	cmd.Flag.Var(&o.Separator, "separator", "separator")
+	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
+	cmd.Flag.StringVar(&o.File, "file", "", "input file")
+	o.registerInput(&cmd.Flag, true)
+	o.registerOutput(&cmd.Flag)
+	cmd.Flag.StringVar(&o.Tag, "tag", "", "tag")
+	cmd.Flag.Var(&memory, "memory", "memory used before spilling rows to temporary files")
+	cmd.Flag.StringVar(&o.Datadir, "tmpdir", os.TempDir(), "directory for temporary files")
+
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	r, err := o.Open("", nil)
+	if err != nil {
+		return err
+	}
+	defer o.Close(r)
+
+	sel, err := parseKeys(cmd.Flag.Arg(0), r.Headers())
+	if err != nil {
+		return fmt.Errorf("selection (key): %s", err)
+	}
+	keys, err := comma.ParseSortKeys(sel, cmd.Flag.Args()[1:])
+	if err != nil {
+		return err
+	}
+	sorter, err := comma.NewSorter(keys, comma.WithMemoryLimit(int64(memory)), comma.WithTempDir(o.Datadir))
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := sorter.Push(row); err != nil {
+			return err
+		}
+	}
+	rs, err := sorter.Sort()
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeDump(dump, &err)
+	if err := dumpHeaders(dump, r.Headers(), o.Tag); err != nil {
+		return err
+	}
+	for {
+		switch row, err := rs.Next(); err {
+		case nil:
+			if o.Tag != "" {
+				row = append([]string{o.Tag}, row...)
+			}
+			dump.Dump(row)
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+	}
 }
 
-func runCross(cmd *cli.Command, args []string) error {
-	return ErrImplemented
+func runJoin(cmd *cli.Command, args []string) (err error) {
+	o := Options{
+		Separator: Comma(','),
+		Width:     DefaultWidth,
+	}
+	var (
+		left, right, on, mode string
+		memory                = Memory(comma.DefaultMemoryLimit)
+	)
+	cmd.Flag.Var(&o.Separator, "separator", "separator")
+	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
+	o.registerInput(&cmd.Flag, false)
+	o.registerOutput(&cmd.Flag)
+	cmd.Flag.StringVar(&left, "left", "", "left input file")
+	cmd.Flag.StringVar(&right, "right", "", "right input file")
+	cmd.Flag.StringVar(&on, "on", "", "keys used to join the files")
+	cmd.Flag.StringVar(&mode, "mode", "inner", "join mode (inner, left, right, full, semi, anti)")
+	cmd.Flag.Var(&memory, "memory", "memory used before spilling rows to temporary files")
+	cmd.Flag.StringVar(&o.Datadir, "tmpdir", os.TempDir(), "directory for temporary files")
+
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if left == "" || right == "" {
+		return fmt.Errorf("join: left and right files should be given")
+	}
+	if left == right && left == "-" {
+		return fmt.Errorf("join: stdin can only be used for one side")
+	}
+	jm, err := comma.ParseJoinMode(mode)
+	if err != nil {
+		return err
+	}
+	rl, err := o.OpenFile(left, "", nil)
+	if err != nil {
+		return err
+	}
+	defer o.Close(rl)
+	rr, err := o.OpenFile(right, "", nil)
+	if err != nil {
+		return err
+	}
+	defer o.Close(rr)
+
+	x := strings.Index(on, "=")
+	if x < 0 {
+		return fmt.Errorf("join: invalid keys %s (want: selection=selection)", on)
+	}
+	lkeys, err := parseKeys(on[:x], rl.Headers())
+	if err != nil {
+		return fmt.Errorf("selection (left key): %s", err)
+	}
+	rkeys, err := parseKeys(on[x+1:], rr.Headers())
+	if err != nil {
+		return fmt.Errorf("selection (right key): %s", err)
+	}
+	j, err := comma.NewJoin(rl, rr, lkeys, rkeys, jm, comma.WithMemoryLimit(int64(memory)), comma.WithTempDir(o.Datadir))
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeDump(dump, &err)
+	if hs := rl.Headers(); len(hs) > 0 {
+		if jm != comma.SemiJoin && jm != comma.AntiJoin {
+			hs = append(append([]string{}, hs...), rr.Headers()...)
+		}
+		if err := dump.Headers(hs); err != nil {
+			return err
+		}
+	}
+	for {
+		switch row, err := j.Next(); err {
+		case nil:
+			if err := dump.Dump(row); err != nil {
+				return err
+			}
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+	}
 }
 
-func runDescribe(cmd *cli.Command, args []string) error {
-	return ErrImplemented
+func runCross(cmd *cli.Command, args []string) (err error) {
+	o := Options{
+		Separator: Comma(','),
+		Width:     DefaultWidth,
+	}
+	var left, right string
+	cmd.Flag.Var(&o.Separator, "separator", "separator")
+	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
+	o.registerInput(&cmd.Flag, false)
+	o.registerOutput(&cmd.Flag)
+	cmd.Flag.StringVar(&left, "left", "", "left input file")
+	cmd.Flag.StringVar(&right, "right", "", "right input file")
+
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if left == "" || right == "" {
+		return fmt.Errorf("cross: left and right files should be given")
+	}
+	rr, err := o.OpenFile(right, "", nil)
+	if err != nil {
+		return err
+	}
+	defer o.Close(rr)
+
+	var rows [][]string
+	for {
+		row, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+
+	rl, err := o.OpenFile(left, "", nil)
+	if err != nil {
+		return err
+	}
+	defer o.Close(rl)
+
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeDump(dump, &err)
+	if hs := rl.Headers(); len(hs) > 0 {
+		hs = append(append([]string{}, hs...), rr.Headers()...)
+		if err := dump.Headers(hs); err != nil {
+			return err
+		}
+	}
+	for {
+		switch row, err := rl.Next(); err {
+		case nil:
+			for _, r := range rows {
+				vs := make([]string, 0, len(row)+len(r))
+				vs = append(vs, row...)
+				if err := dump.Dump(append(vs, r...)); err != nil {
+					return err
+				}
+			}
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+	}
 }
 
-func runEval(cmd *cli.Command, args []string) error {
+func runEval(cmd *cli.Command, args []string) (err error) {
 	o := Options{
 		Separator: Comma(','),
 		Width:     DefaultWidth,
@@ -177,41 +568,72 @@ func runEval(cmd *cli.Command, args []string) error {
 	cmd.Flag.IntVar(&o.Limit, "limit", 0, "show N first rows")
 	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
 	cmd.Flag.StringVar(&o.File, "file", "", "input file")
+	o.registerInput(&cmd.Flag, true)
 	cmd.Flag.StringVar(&o.Tag, "tag", "", "tag")
-	cmd.Flag.BoolVar(&o.Table, "table", false, "print data in table format")
+	o.registerOutput(&cmd.Flag)
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	e, err := comma.Eval(cmd.Flag.Args())
+	r, err := o.Open("", nil)
+	if err != nil {
+		return err
+	}
+	defer o.Close(r)
+
+	e, err := comma.EvalWithHeaders(cmd.Flag.Args(), r.Headers(), r.Nulls().Tokens()...)
 	if err != nil {
 		return err
 	}
 
-	r, err := o.Open("", nil)
+	dump, err := o.Dump(os.Stdout)
 	if err != nil {
 		return err
 	}
-	defer r.Close()
+	defer closeDump(dump, &err)
 
-	dump := Dump(os.Stdout, o.Width, o.Table)
+	// headers are written with the first row: the columns added by the
+	// expressions are named after their position.
+	var (
+		headers = r.Headers()
+		written bool
+	)
+	writeHeaders := func(n int) error {
+		if written || len(headers) == 0 {
+			return nil
+		}
+		written = true
+		hs := append([]string{}, headers...)
+		for len(hs) < n {
+			hs = append(hs, strconv.Itoa(len(hs)+1))
+		}
+		return dumpHeaders(dump, hs, o.Tag)
+	}
 	for {
 		switch row, err := r.Next(); err {
 		case nil:
+			raw := append([]string{}, row...)
 			row, err := e.Eval(row)
 			if err != nil {
+				if err := r.Reject(raw, fmt.Errorf("eval: %w", err)); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := writeHeaders(len(row)); err != nil {
 				return err
 			}
 			if o.Tag != "" {
 				row = append([]string{o.Tag}, row...)
 			}
-			dump.Dump(row)
+			if err := dump.Dump(row); err != nil {
+				return err
+			}
 		case io.EOF:
-			return nil
+			return writeHeaders(0)
 		default:
 			return err
 		}
 	}
-	return ErrImplemented
 }
 
 func runCat(cmd *cli.Command, args []string) error {
@@ -221,7 +643,7 @@ func runCat(cmd *cli.Command, args []string) error {
 	cmd.Flag.Var(&o.Separator, "separator", "separator")
 	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
 	cmd.Flag.BoolVar(&o.Append, "append", false, "append")
-	cmd.Flag.BoolVar(&o.Table, "table", false, "print data in table format")
+	o.registerOutput(&cmd.Flag)
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
@@ -234,7 +656,7 @@ func runCat(cmd *cli.Command, args []string) error {
 	return cat(cmd.Flag.Args(), o)
 }
 
-func appendRows(files []string, o Options) error {
+func appendRows(files []string, o Options) (err error) {
 	var rs []io.Reader
 	for _, f := range files {
 		r, err := os.Open(f)
@@ -244,7 +666,11 @@ func appendRows(files []string, o Options) error {
 		defer r.Close()
 		rs = append(rs, r)
 	}
-	dump := Dump(os.Stdout, o.Width, o.Table)
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeDump(dump, &err)
 
 	rc := csv.NewReader(io.MultiReader(rs...))
 	rc.Comma = o.Separator.Rune()
@@ -260,7 +686,7 @@ func appendRows(files []string, o Options) error {
 	}
 }
 
-func appendColumns(files []string, o Options) error {
+func appendColumns(files []string, o Options) (err error) {
 	rs := make([]*csv.Reader, len(files))
 	for i, f := range files {
 		r, err := os.Open(f)
@@ -276,7 +702,11 @@ func appendColumns(files []string, o Options) error {
 	cols := make([][]string, len(rs))
 
 	var done int
-	dump := Dump(os.Stdout, o.Width, o.Table)
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeDump(dump, &err)
 	for {
 		var row []string
 		for i := 0; i < len(rs); i++ {
@@ -305,7 +735,7 @@ func appendColumns(files []string, o Options) error {
 	return nil
 }
 
-func runSplit(cmd *cli.Command, args []string) error {
+func runSplit(cmd *cli.Command, args []string) (err error) {
 	o := Options{
 		Datadir:   os.TempDir(),
 		Separator: Comma(','),
@@ -315,39 +745,53 @@ func runSplit(cmd *cli.Command, args []string) error {
 	cmd.Flag.StringVar(&o.Datadir, "datadir", o.Datadir, "")
 	cmd.Flag.StringVar(&o.Prefix, "prefix", o.Prefix, "")
 	cmd.Flag.StringVar(&o.File, "file", o.File, "")
+	o.registerInput(&cmd.Flag, true)
+	compress := cmd.Flag.String("compress", "", "compress output files (gzip, zstd, xz)")
 
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
+	if *compress != "" && comma.CompressExt(*compress) == "" {
+		return fmt.Errorf("unsupported compression %s", *compress)
+	}
 
 	if err := os.MkdirAll(o.Datadir, 0755); err != nil {
 		return err
 	}
 
-	sel, err := comma.ParseSelection(cmd.Flag.Arg(0))
+	r, err := o.Open("", nil)
+	if err != nil {
+		return err
+	}
+	defer o.Close(r)
+
+	sel, err := parseKeys(cmd.Flag.Arg(0), r.Headers())
 	if err != nil {
 		return fmt.Errorf("selection (key): %s", err)
 	}
 	var filter *comma.Filter
-	if f, err := comma.ParseFilter(cmd.Flag.Arg(1)); err == nil {
+	if f, err := comma.ParseFilterWithHeaders(cmd.Flag.Arg(1), r.Headers(), r.Nulls().Tokens()...); err == nil {
 		filter = f
 	} else {
 		return fmt.Errorf("filter: %s", err)
 	}
 
-	r, err := o.Open("", nil)
-	if err != nil {
-		return err
-	}
-	defer r.Close()
-
-	dumps := make(map[string]*Dumper)
+	dumps := make(map[string]Dumper)
+	defer func() {
+		// closing a chunk flushes it: its error is returned unless another
+		// error already stopped the split.
+		for _, d := range dumps {
+			if e := d.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}()
 	for {
 		switch row, err := r.Filter(filter); err {
 		case nil:
 			ds, id := selectKeys(sel, row)
 			if _, ok := dumps[id]; !ok {
-				file := strings.Join(ds, "_") + ".csv"
+				file := strings.Join(ds, "_") + ".csv" + comma.CompressExt(*compress)
 				if o.Prefix != "" {
 					file = o.Prefix + "-" + file
 				}
@@ -359,9 +803,17 @@ func runSplit(cmd *cli.Command, args []string) error {
 				if err != nil {
 					return err
 				}
-				defer f.Close()
-
-				dumps[id] = Dump(f, o.Width, false)
+				w, err := comma.Compress(f, *compress)
+				if err != nil {
+					f.Close()
+					return err
+				}
+				d, err := o.Dump(w)
+				if err != nil {
+					w.Close()
+					return err
+				}
+				dumps[id] = d
 			}
 			if err := dumps[id].Dump(row); err != nil {
 				return err
@@ -374,7 +826,7 @@ func runSplit(cmd *cli.Command, args []string) error {
 	}
 }
 
-func runTable(cmd *cli.Command, args []string) error {
+func runTable(cmd *cli.Command, args []string) (err error) {
 	o := Options{
 		Separator: Comma(','),
 		Width:     DefaultWidth,
@@ -383,6 +835,7 @@ func runTable(cmd *cli.Command, args []string) error {
 	cmd.Flag.IntVar(&o.Limit, "limit", 0, "show N first rows")
 	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
 	cmd.Flag.StringVar(&o.File, "file", "", "input file")
+	o.registerInput(&cmd.Flag, true)
 	cmd.Flag.StringVar(&o.Tag, "tag", "", "tag")
 
 	if err := cmd.Flag.Parse(args); err != nil {
@@ -392,15 +845,21 @@ func runTable(cmd *cli.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	defer r.Close()
+	defer o.Close(r)
+
+	o.Output = outputTable
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeDump(dump, &err)
 
-	dump := Dump(os.Stdout, o.Width, true)
 	headers := cmd.Flag.Args()
-	if len(headers) > 0 {
-		if o.Tag != "" {
-			headers = append([]string{"tag"}, headers...)
-		}
-		dump.Dump(headers)
+	if len(headers) == 0 {
+		headers = r.Headers()
+	}
+	if err := dumpHeaders(dump, headers, o.Tag); err != nil {
+		return err
 	}
 	for i := 0; o.Limit <= 0 || i < o.Limit; i++ {
 		switch row, err := r.Next(); err {
@@ -421,7 +880,7 @@ func runTable(cmd *cli.Command, args []string) error {
 	return nil
 }
 
-func runTranspose(cmd *cli.Command, args []string) error {
+func runTranspose(cmd *cli.Command, args []string) (err error) {
 	o := Options{
 		Separator: Comma(','),
 		Width:     DefaultWidth,
@@ -429,7 +888,8 @@ func runTranspose(cmd *cli.Command, args []string) error {
 	cmd.Flag.Var(&o.Separator, "separator", "separator")
 	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
 	cmd.Flag.StringVar(&o.File, "file", "", "input file")
-	cmd.Flag.BoolVar(&o.Table, "table", false, "print data in table format")
+	o.registerInput(&cmd.Flag, true)
+	o.registerOutput(&cmd.Flag)
 
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
@@ -438,33 +898,44 @@ func runTranspose(cmd *cli.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	defer r.Close()
+	defer o.Close(r)
 
 	var rows [][]string
+	if hs := r.Headers(); len(hs) > 0 {
+		rows = make([][]string, len(hs))
+		for i, h := range hs {
+			rows[i] = append(rows[i], h)
+		}
+	}
 	for {
-		switch row, err := r.Next(); err {
-		case nil:
-			if n := len(rows); n == 0 {
-				rows = make([][]string, len(row))
-			}
-			for i, r := range row {
-				rows[i] = append(rows[i], r)
-			}
-		case io.EOF:
-			dump := Dump(os.Stdout, o.Width, o.Table)
-			for _, r := range rows {
-				if err := dump.Dump(r); err != nil {
-					return err
-				}
-			}
-			return nil
-		default:
+		row, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if n := len(rows); n == 0 {
+			rows = make([][]string, len(row))
+		}
+		for i, r := range row {
+			rows[i] = append(rows[i], r)
+		}
+	}
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeDump(dump, &err)
+	for _, r := range rows {
+		if err := dump.Dump(r); err != nil {
 			return err
 		}
 	}
+	return nil
 }
 
-func runFrequency(cmd *cli.Command, args []string) error {
+func runFrequency(cmd *cli.Command, args []string) (err error) {
 	o := Options{
 		Separator: Comma(','),
 		Width:     DefaultWidth,
@@ -472,75 +943,114 @@ func runFrequency(cmd *cli.Command, args []string) error {
 	cmd.Flag.Var(&o.Separator, "separator", "separator")
 	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
 	cmd.Flag.StringVar(&o.File, "file", "", "input file")
+	o.registerInput(&cmd.Flag, true)
 	cmd.Flag.BoolVar(&o.Append, "count", false, "append count column per group")
-	cmd.Flag.BoolVar(&o.Table, "table", false, "print data in table format")
+	o.registerOutput(&cmd.Flag)
 	cmd.Flag.StringVar(&o.Tag, "tag", "", "tag")
-	reverse := cmd.Flag.Bool("reverse", false, "reverse")
+	order := Order{By: orderKey}
+	cmd.Flag.Var(&order, "order", orderUsage)
+	cmd.Flag.BoolVar(&order.Reverse, "reverse", false, "reverse")
+	cmd.Flag.IntVar(&order.Top, "top", 0, "only write the N first groups")
+	var having Having
+	cmd.Flag.StringVar(&having.Expr, "having", "", "only write the groups matching the expression")
 
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	sel, err := comma.ParseSelection(cmd.Flag.Arg(0))
+	r, err := o.Open("", nil)
 	if err != nil {
-		return fmt.Errorf("selection (key): %s", err)
+		return err
 	}
+	defer o.Close(r)
 
-	r, err := o.Open("", nil)
+	sel, err := parseKeys(cmd.Flag.Arg(0), r.Headers())
 	if err != nil {
-		return err
+		return fmt.Errorf("selection (key): %s", err)
 	}
-	defer r.Close()
 
 	cumul := Aggr{
 		sel:    sel,
 		single: true,
 		Aggr:   comma.Count(),
 	}
-	data := Tree{
-		Sel:     sel,
-		Reverse: *reverse,
-	}
+	data := Groups{Sel: sel}
 	for {
-		switch row, err := r.Next(); err {
-		case nil:
-			if err := data.Upsert(row); err != nil {
-				return err
-			}
-			if err := cumul.Update(row); err != nil {
-				return err
+		row, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := data.Upsert(row); err != nil {
+			return err
+		}
+		if err := cumul.Update(row); err != nil {
+			return err
+		}
+	}
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeDump(dump, &err)
+	headers := r.Headers()
+	if hs := headers; len(hs) > 0 {
+		hs, _ = selectKeys(sel, hs)
+		hs = append(hs, "count", "cumul", "percent", "cumul_percent")
+		if err := dumpHeaders(dump, hs, o.Tag); err != nil {
+			return err
+		}
+	}
+
+	var (
+		results  = cumul.Result()
+		sums     = make([]float64, len(results))
+		percents = make([]float64, len(results))
+		count    int
+	)
+	for _, r := range data.Rows(order) {
+		if order.Top > 0 && count >= order.Top {
+			break
+		}
+		var (
+			row []string
+			vs  = textValues(r.Keys)
+		)
+		if o.Tag != "" {
+			row = append(row, o.Tag)
+		}
+		row = append(row, r.Keys...)
+		for i, r := range r.results() {
+			r := toFloat(r)
+			sums[i] += r
+			percent := r / toFloat(results[i])
+			percents[i] += percent
+			row = append(row, formatFloat(r), formatFloat(sums[i]), formatPercent(percent), formatPercent(percents[i]))
+			vs = append(vs, eval.Literal(r), eval.Literal(sums[i]), eval.Literal(percent*100), eval.Literal(percents[i]*100))
+		}
+		ok, err := having.Match(vs, func() []string {
+			names, _ := selectKeys(sel, headers)
+			if len(names) != len(r.Keys) {
+				names = make([]string, len(r.Keys))
 			}
-		case io.EOF:
-			line, results := Line(o.Table), cumul.Result()
-			sums := make([]float64, len(results))
-			percents := make([]float64, len(results))
-			data.Traverse(func(r *Row) {
-				if o.Tag != "" {
-					line.AppendString(o.Tag, o.Width, linewriter.AlignRight)
-				}
-				for _, v := range r.Keys {
-					line.AppendString(v, o.Width, linewriter.AlignRight)
-				}
-				for _, d := range r.Data {
-					for i, r := range d.Result() {
-						sums[i] += r
-						percent := r / results[i]
-						percents[i] += percent
-						line.AppendFloat(r, o.Width, 2, linewriter.AlignRight|linewriter.Float)
-						line.AppendFloat(sums[i], o.Width, 2, linewriter.AlignRight|linewriter.Float)
-						line.AppendPercent(percent, o.Width, 2, linewriter.AlignRight)
-						line.AppendPercent(percents[i], o.Width, 2, linewriter.AlignRight)
-					}
-				}
-				io.Copy(os.Stdout, line)
-			})
-			return nil
-		default:
+			return append(names, "count", "cumul", "percent", "cumul_percent")
+		})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		count++
+		if err := dump.Dump(row); err != nil {
 			return err
 		}
 	}
+	return nil
 }
 
-func runGroup(cmd *cli.Command, args []string) error {
+func runGroup(cmd *cli.Command, args []string) (err error) {
 	// defer profile.Start(profile.CPUProfile).Stop()
 	o := Options{
 		Separator: Comma(','),
@@ -549,60 +1059,108 @@ func runGroup(cmd *cli.Command, args []string) error {
 	cmd.Flag.Var(&o.Separator, "separator", "separator")
 	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
 	cmd.Flag.StringVar(&o.File, "file", "", "input file")
-	cmd.Flag.BoolVar(&o.Table, "table", false, "print data in table format")
+	o.registerInput(&cmd.Flag, true)
+	o.registerOutput(&cmd.Flag)
 	cmd.Flag.StringVar(&o.Tag, "tag", "", "tag")
-	reverse := cmd.Flag.Bool("reverse", false, "reverse")
+	order := Order{By: orderKey}
+	cmd.Flag.Var(&order, "order", orderUsage)
+	cmd.Flag.BoolVar(&order.Reverse, "reverse", false, "reverse")
+	cmd.Flag.IntVar(&order.Top, "top", 0, "only write the N first groups")
+	var having Having
+	cmd.Flag.StringVar(&having.Expr, "having", "", "only write the groups matching the expression")
+	rollup := cmd.Flag.Bool("rollup", false, "add subtotals for each prefix of the keys and a grand total")
+	cube := cmd.Flag.Bool("cube", false, "add subtotals for each combination of the keys and a grand total")
+	placeholder := cmd.Flag.String("placeholder", "*", "value of the keys rolled up in the subtotals")
+	workers := cmd.Flag.Int("workers", runtime.NumCPU(), "number of goroutines grouping the rows")
 
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	sel, err := comma.ParseSelection(cmd.Flag.Arg(0))
+	if *rollup && *cube {
+		return fmt.Errorf("rollup and cube can not be used together")
+	}
+	r, err := o.Open("", nil)
+	if err != nil {
+		return err
+	}
+	defer o.Close(r)
+
+	sel, err := parseKeys(cmd.Flag.Arg(0), r.Headers())
 	if err != nil {
 		return fmt.Errorf("selection (key): %s", err)
 	}
 
-	r, err := o.Open("", nil)
+	ops := cmd.Flag.Args()
+	if _, err := parseAggr(ops[1:], r.Headers()); err != nil {
+		return err
+	}
+	var sets func(int) [][]bool
+	switch {
+	case *rollup:
+		sets = rollupSets
+	case *cube:
+		sets = cubeSets
+	}
+	data, err := groupRows(r, *workers, func() *Groups {
+		return &Groups{
+			Ops:         ops[1:],
+			Sel:         sel,
+			Headers:     r.Headers(),
+			Nulls:       r.Nulls(),
+			Sets:        sets,
+			Placeholder: *placeholder,
+		}
+	})
 	if err != nil {
 		return err
 	}
-	defer r.Close()
 
-	ops := cmd.Flag.Args()
-	data := Tree{
-		Ops:     ops[1:],
-		Sel:     sel,
-		Reverse: *reverse,
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
 	}
-	for {
-		switch row, err := r.Next(); err {
-		case nil:
-			if err := data.Upsert(row); err != nil {
-				return err
-			}
-		case io.EOF:
-			line := Line(o.Table)
-			data.Traverse(func(r *Row) {
-				if o.Tag != "" {
-					line.AppendString(o.Tag, o.Width, linewriter.AlignRight)
-				}
-				for _, v := range r.Keys {
-					line.AppendString(v, o.Width, linewriter.AlignRight)
-				}
-				for _, d := range r.Data {
-					for _, r := range d.Result() {
-						line.AppendFloat(r, o.Width, 2, linewriter.AlignRight|linewriter.Float)
-					}
-				}
-				io.Copy(os.Stdout, line)
-			})
-			return nil
-		default:
+	defer closeDump(dump, &err)
+	headers := r.Headers()
+	if hs := headers; len(hs) > 0 {
+		hs, _ = selectKeys(sel, hs)
+		hs = append(hs, aggrHeaders(ops[1:], headers)...)
+		if err := dumpHeaders(dump, hs, o.Tag); err != nil {
 			return err
 		}
 	}
+	var count int
+	for _, r := range data.Rows(order) {
+		if order.Top > 0 && count >= order.Top {
+			break
+		}
+		vs := r.results()
+		ok, err := having.Match(append(textValues(r.Keys), vs...), func() []string {
+			return groupNames(r, sel, ops[1:], headers)
+		})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		count++
+
+		var row []string
+		if o.Tag != "" {
+			row = append(row, o.Tag)
+		}
+		row = append(row, r.Keys...)
+		for _, v := range vs {
+			row = append(row, formatValue(v))
+		}
+		if err := dump.Dump(row); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func runFormat(cmd *cli.Command, args []string) error {
+func runFormat(cmd *cli.Command, args []string) (err error) {
 	o := Options{
 		Separator: Comma(','),
 		Width:     DefaultWidth,
@@ -610,7 +1168,8 @@ func runFormat(cmd *cli.Command, args []string) error {
 	cmd.Flag.Var(&o.Separator, "separator", "separator")
 	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
 	cmd.Flag.StringVar(&o.File, "file", "", "input file")
-	cmd.Flag.BoolVar(&o.Table, "table", false, "print data in table format")
+	o.registerInput(&cmd.Flag, true)
+	o.registerOutput(&cmd.Flag)
 	cmd.Flag.StringVar(&o.Tag, "tag", "", "tag")
 
 	if err := cmd.Flag.Parse(args); err != nil {
@@ -620,9 +1179,16 @@ func runFormat(cmd *cli.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	defer r.Close()
+	defer o.Close(r)
 
-	dump := Dump(os.Stdout, o.Width, o.Table)
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeDump(dump, &err)
+	if err := dumpHeaders(dump, r.Headers(), o.Tag); err != nil {
+		return err
+	}
 	for {
 		switch row, err := r.Next(); err {
 		case nil:
@@ -636,11 +1202,9 @@ func runFormat(cmd *cli.Command, args []string) error {
 			return err
 		}
 	}
-
-	return nil
 }
 
-func runFilter(cmd *cli.Command, args []string) error {
+func runFilter(cmd *cli.Command, args []string) (err error) {
 	o := Options{
 		Separator: Comma(','),
 		Width:     DefaultWidth,
@@ -649,23 +1213,32 @@ func runFilter(cmd *cli.Command, args []string) error {
 	cmd.Flag.Var(&o.Separator, "separator", "separator")
 	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
 	cmd.Flag.StringVar(&o.File, "file", "", "input file")
-	cmd.Flag.BoolVar(&o.Table, "table", false, "print data in table format")
+	o.registerInput(&cmd.Flag, true)
+	o.registerOutput(&cmd.Flag)
 	cmd.Flag.StringVar(&o.Tag, "tag", "", "tag")
 
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	match, err := comma.ParseFilter(cmd.Flag.Arg(0))
+	r, err := o.Open("", nil)
+	if err != nil {
+		return err
+	}
+	defer o.Close(r)
+
+	match, err := comma.ParseFilterWithHeaders(cmd.Flag.Arg(0), r.Headers(), r.Nulls().Tokens()...)
 	if err != nil {
 		return fmt.Errorf("filter: %s", err)
 	}
-	r, err := o.Open("", nil)
+
+	dump, err := o.Dump(os.Stdout)
 	if err != nil {
 		return err
 	}
-	defer r.Close()
-
-	dump := Dump(os.Stdout, o.Width, o.Table)
+	defer closeDump(dump, &err)
+	if err := dumpHeaders(dump, r.Headers(), o.Tag); err != nil {
+		return err
+	}
 	for {
 		switch row, err := r.Filter(match); err {
 		case nil:
@@ -681,7 +1254,7 @@ func runFilter(cmd *cli.Command, args []string) error {
 	}
 }
 
-func runSelect(cmd *cli.Command, args []string) error {
+func runSelect(cmd *cli.Command, args []string) (err error) {
 	o := Options{
 		Separator: Comma(','),
 		Width:     DefaultWidth,
@@ -689,7 +1262,8 @@ func runSelect(cmd *cli.Command, args []string) error {
 	cmd.Flag.Var(&o.Separator, "separator", "separator")
 	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
 	cmd.Flag.StringVar(&o.File, "file", "", "input file")
-	cmd.Flag.BoolVar(&o.Table, "table", false, "print data in table format")
+	o.registerInput(&cmd.Flag, true)
+	o.registerOutput(&cmd.Flag)
 	cmd.Flag.StringVar(&o.Tag, "tag", "", "tag")
 
 	if err := cmd.Flag.Parse(args); err != nil {
@@ -699,9 +1273,16 @@ func runSelect(cmd *cli.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	defer r.Close()
+	defer o.Close(r)
 
-	dump := Dump(os.Stdout, o.Width, o.Table)
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeDump(dump, &err)
+	if err := dumpHeaders(dump, r.Headers(), o.Tag); err != nil {
+		return err
+	}
 	for {
 		switch row, err := r.Next(); err {
 		case nil:
@@ -717,64 +1298,6 @@ func runSelect(cmd *cli.Command, args []string) error {
 	}
 }
 
-const DefaultWidth = 10
-
-func Line(table bool) *linewriter.Writer {
-	var options []linewriter.Option
-	if table {
-		options = []linewriter.Option{
-			linewriter.WithSeparator([]byte("|")),
-			linewriter.WithPadding([]byte(" ")),
-		}
-	} else {
-		options = append(options, linewriter.AsCSV(false))
-	}
-	return linewriter.NewWriter(4096, options...)
-}
-
-type Dumper struct {
-	Width int
-
-	line  *linewriter.Writer
-	inner io.Writer
-
-	io.Closer
-}
-
-func Dump(w io.Writer, width int, table bool) *Dumper {
-	if width <= 0 {
-		width = DefaultWidth
-	}
-	d := Dumper{
-		line:  Line(table),
-		inner: w,
-		Width: width,
-	}
-	if c, ok := w.(io.Closer); ok {
-		d.Closer = c
-	}
-	return &d
-}
-
-func (d *Dumper) Close() error {
-	var err error
-	if d.Closer != nil {
-		err = d.Closer.Close()
-	}
-	return err
-}
-
-func (d *Dumper) Dump(row []string) error {
-	for i := 0; i < len(row); i++ {
-		d.line.AppendString(row[i], d.Width, linewriter.AlignRight)
-	}
-	n, err := io.Copy(d.inner, d.line)
-	if err == io.EOF && n > 0 {
-		err = nil
-	}
-	return err
-}
-
 type Aggr struct {
 	sel    []comma.Selection
 	single bool
@@ -797,14 +1320,22 @@ func (a *Aggr) Update(row []string) error {
 	return nil
 }
 
-func parseAggr(vs []string) ([]Aggr, error) {
+func parseKeys(str string, headers []string) ([]comma.Selection, error) {
+	sel, err := comma.ParseSelection(str)
+	if err != nil {
+		return nil, err
+	}
+	return comma.ResolveSelection(sel, headers)
+}
+
+func parseAggr(vs, headers []string) ([]Aggr, error) {
 	if mod := len(vs) % 2; mod != 0 {
 		return nil, fmt.Errorf("no enough argument")
 	}
 	var as []Aggr
 	for i := 0; i < len(vs); i += 2 {
 		op, sel := vs[i], vs[i+1]
-		s, err := comma.ParseSelection(sel)
+		s, err := parseKeys(sel, headers)
 		if err != nil {
 			return nil, err
 		}
@@ -820,177 +1351,161 @@ func parseAggr(vs []string) ([]Aggr, error) {
 			a = comma.Max()
 		case "count":
 			a = comma.Count()
+		case "var", "var_samp", "variance":
+			a = comma.Variance()
+		case "var_pop":
+			a = comma.PopVariance()
+		case "stddev", "stddev_samp", "std":
+			a = comma.Stddev()
+		case "stddev_pop":
+			a = comma.PopStddev()
+		case "skew", "skew_samp":
+			a = comma.Skewness()
+		case "skew_pop":
+			a = comma.PopSkewness()
+		case "kurt", "kurt_samp":
+			a = comma.Kurtosis()
+		case "kurt_pop":
+			a = comma.PopKurtosis()
+		case "first":
+			a = comma.First()
+		case "last":
+			a = comma.Last()
+		case "mode":
+			a = comma.Mode()
+		case "concat":
+			a = comma.Concat(";")
+		case "collect_set":
+			a = comma.CollectSet(";")
+		case "median":
+			a = comma.Median()
+		case "distinct":
+			a = comma.Distinct()
 		default:
-			return nil, fmt.Errorf("unknown operation %s", op)
+			if a, err = parseParamAggr(op); err != nil {
+				return nil, err
+			}
 		}
 		as = append(as, Aggr{sel: s, Aggr: a})
 	}
 	return as, nil
 }
 
-type Row struct {
-	Keys []string
-	Hash string
-
-	Data []Aggr
-}
-
-func (r *Row) Update(row []string) error {
-	for _, d := range r.Data {
-		if err := d.Update(row); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-type Tree struct {
-	root *Node
-	Ops  []string
-	Sel  []comma.Selection
-
-	Reverse bool
-}
-
-func (t *Tree) Find(ks []string) *Row {
-	if t.root == nil {
-		return nil
-	}
-	return t.root.Find(ks)
-}
-
-func (t *Tree) Upsert(vs []string) error {
-	ks := t.selectKeys(vs)
-	if len(ks) == 0 {
-		return nil
+// parseParamAggr gives the aggregates that take a parameter: concat(sep),
+// collect_set(sep), distinct(precision), min:type[:pattern],
+// max:type[:pattern], pNN, quantile(q) and quantile_exact(q).
+func parseParamAggr(op string) (comma.Aggr, error) {
+	if sep, ok := parseArgument(op, "concat"); ok {
+		return comma.Concat(sep), nil
 	}
-	var r *Row
-	if t.root == nil {
-		t.root = nodeFromKeys(ks)
-		r = t.root.Row
-	} else {
-		r = t.root.Upsert(ks, t.Reverse)
+	if sep, ok := parseArgument(op, "collect_set"); ok {
+		return comma.CollectSet(sep), nil
 	}
-	if len(r.Data) == 0 {
-		var (
-			as  []Aggr
-			err error
-		)
-		if len(t.Ops) == 0 {
-			a := Aggr{
-				sel:    t.Sel,
-				single: true,
-				Aggr:   comma.Count(),
-			}
-			as = []Aggr{a}
-		} else {
-			as, err = parseAggr(t.Ops)
-		}
+	if p, ok := parseArgument(op, "distinct"); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("%s: invalid precision", op)
 		}
-		r.Data = append(r.Data, as...)
+		return comma.DistinctWithPrecision(n)
 	}
-	return r.Update(vs)
-}
-
-func (t *Tree) selectKeys(row []string) []string {
-	ds := make([]string, 0, len(t.Sel)+1)
-	for i := 0; i < len(t.Sel); i++ {
-		vs, err := t.Sel[i].Select(row)
-		if err != nil {
-			return nil
+	if x := strings.Index(op, ":"); x >= 0 {
+		switch strings.ToLower(op[:x]) {
+		case "min":
+			return comma.MinOf(op[x+1:])
+		case "max":
+			return comma.MaxOf(op[x+1:])
+		default:
+			return nil, fmt.Errorf("unknown operation %s", op)
 		}
-		ds = append(ds, vs...)
 	}
-	return ds
+	return parseQuantile(op)
 }
 
-func (t *Tree) Traverse(fn func(r *Row)) {
-	if t.root == nil {
-		return
+// parseQuantile gives the quantile of operations like p90 (percentile),
+// quantile(0.9) or quantile_exact(0.9). The last one keeps all the values in
+// memory instead of switching to an estimate for large groups.
+func parseQuantile(op string) (comma.Aggr, error) {
+	var (
+		str    = strings.ToLower(op)
+		div    = 1.0
+		create = comma.Quantile
+	)
+	if arg, ok := parseArgument(str, "quantile"); ok {
+		str = arg
+	} else if arg, ok := parseArgument(str, "quantile_exact"); ok {
+		str, create = arg, comma.ExactQuantile
+	} else if len(str) > 1 && str[0] == 'p' {
+		str, div = str[1:], 100
 	} else {
-		t.root.Traverse(fn)
-	}
-}
-
-type Node struct {
-	*Row
-	Left  *Node
-	Right *Node
-}
-
-func (n *Node) Find(ks []string) *Row {
-	switch cmp := compareKeys(n.Keys, ks); cmp {
-	default:
-		return n.Row
-	case -1:
-		if n.IsLeaf() || n.Left == nil {
-			return nil
-		}
-		return n.Left.Find(ks)
-	case 1:
-		if n.IsLeaf() || n.Right == nil {
-			return nil
-		}
-		return n.Right.Find(ks)
+		return nil, fmt.Errorf("unknown operation %s", op)
 	}
-}
-
-func (n *Node) Upsert(ks []string, reverse bool) *Row {
-	var r *Row
-	cmp := compareKeys(n.Keys, ks)
-	if reverse {
-		cmp = -cmp
+	q, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil {
+		return nil, fmt.Errorf("unknown operation %s", op)
 	}
-	switch cmp {
-	default:
-		r = n.Row
-	case -1:
-		if n.Left == nil {
-			n.Left = nodeFromKeys(ks)
-			r = n.Left.Row
-		} else {
-			r = n.Left.Upsert(ks, reverse)
-		}
-	case 1:
-		if n.Right == nil {
-			n.Right = nodeFromKeys(ks)
-			r = n.Right.Row
-		} else {
-			r = n.Right.Upsert(ks, reverse)
-		}
+	if q /= div; q < 0 || q > 1 {
+		return nil, fmt.Errorf("%s: quantile out of range", op)
 	}
-	return r
+	return create(q), nil
 }
 
-func (n *Node) Traverse(fn func(*Row)) {
-	if !n.IsLeaf() && n.Left != nil {
-		n.Left.Traverse(fn)
+// parseArgument gives the argument of operations written as name(arg). Only
+// the name is case insensitive: the argument is kept as written, except for
+// the quotes of an argument given as a quoted string (eg: concat(" | ")).
+func parseArgument(op, name string) (string, bool) {
+	if len(op) <= len(name) || !strings.EqualFold(op[:len(name)+1], name+"(") || !strings.HasSuffix(op, ")") {
+		return "", false
 	}
-	fn(n.Row)
-	if !n.IsLeaf() && n.Right != nil {
-		n.Right.Traverse(fn)
+	arg := op[len(name)+1 : len(op)-1]
+	if str, err := strconv.Unquote(strings.TrimSpace(arg)); err == nil {
+		arg = str
 	}
+	return arg, true
 }
 
-func (n *Node) IsLeaf() bool {
-	return n.Left == nil && n.Right == nil
+// aggrName gives the name of an operation without its type - min:date gives
+// min.
+func aggrName(op string) string {
+	op = strings.ToLower(op)
+	if x := strings.Index(op, ":"); x >= 0 {
+		op = op[:x]
+	}
+	return op
 }
 
-func compareKeys(k1, k2 []string) int {
-	for i := 0; i < len(k1); i++ {
-		c := strings.Compare(k1[i], k2[i])
-		if c != 0 {
-			return c
+// aggrHeaders gives a name to each column produced by the operations of
+// group: the name of the operation if it is used once on a single column
+// and the name of the operation followed by the name of the column otherwise.
+func aggrHeaders(ops, headers []string) []string {
+	if len(ops) == 0 {
+		return []string{"count"}
+	}
+	var (
+		names [][]string
+		seen  = make(map[string]int)
+	)
+	for i := 0; i+1 < len(ops); i += 2 {
+		op := aggrName(ops[i])
+		sel, err := parseKeys(ops[i+1], headers)
+		if err != nil {
+			return nil
+		}
+		cols, _ := selectKeys(sel, headers)
+		names = append(names, cols)
+		seen[op] += len(cols)
+	}
+	var hs []string
+	for i, cols := range names {
+		op := aggrName(ops[i*2])
+		for _, c := range cols {
+			if seen[op] > 1 {
+				hs = append(hs, op+"_"+c)
+			} else {
+				hs = append(hs, op)
+			}
 		}
 	}
-	return 0
-}
-
-func nodeFromKeys(ks []string) *Node {
-	r := Row{Keys: ks}
-	return &Node{Row: &r}
+	return hs
 }
 
 func selectKeys(sel []comma.Selection, row []string) ([]string, string) {
@@ -1002,5 +1517,5 @@ func selectKeys(sel []comma.Selection, row []string) ([]string, string) {
 		}
 		ds = append(ds, vs...)
 	}
-	return ds, strings.Join(ds, "/")
+	return ds, strings.Join(ds, "\x00")
 }
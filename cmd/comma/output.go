@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/midbel/comma"
+	"github.com/midbel/comma/eval"
+	"github.com/midbel/linewriter"
+)
+
+const DefaultWidth = 10
+
+const (
+	outputCSV      = "csv"
+	outputTSV      = "tsv"
+	outputTable    = "table"
+	outputJSON     = "json"
+	outputNDJSON   = "ndjson"
+	outputMarkdown = "markdown"
+	outputHTML     = "html"
+	outputXML      = "xml"
+)
+
+const outputUsage = "output format (csv, tsv, table, json, ndjson, markdown, html, xml)"
+
+// Dumper writes rows in one of the output formats supported by comma. The
+// names of the columns, when known, should be given with Headers before
+// the first row. Close terminates the output and should always be called.
+type Dumper interface {
+	Headers([]string) error
+	Dump([]string) error
+	io.Closer
+}
+
+// Dump creates the Dumper for the output format selected in the Options.
+func (o Options) Dump(w io.Writer) (Dumper, error) {
+	format := strings.ToLower(o.Output)
+	if format == "" && o.Table {
+		format = outputTable
+	}
+	width := o.Width
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	switch format {
+	case "", outputCSV:
+		return dumpCSV(w, ',')
+	case outputTSV:
+		return dumpCSV(w, '\t')
+	case outputTable:
+		return dumpTable(w, width), nil
+	case outputJSON, outputNDJSON:
+		return dumpJSON(w, format == outputNDJSON), nil
+	case outputMarkdown, "md":
+		return dumpMarkdown(w), nil
+	case outputHTML:
+		return dumpHTML(w), nil
+	case outputXML:
+		return dumpXML(w), nil
+	default:
+		return nil, fmt.Errorf("unknown output format %s", o.Output)
+	}
+}
+
+func dumpHeaders(d Dumper, headers []string, tag string) error {
+	if len(headers) == 0 {
+		return nil
+	}
+	if tag != "" {
+		headers = append([]string{"tag"}, headers...)
+	}
+	return d.Headers(headers)
+}
+
+// closeDump closes d and gives its error to err unless err is already set:
+// the output is terminated and flushed by Close.
+func closeDump(d Dumper, err *error) {
+	if e := d.Close(); e != nil && *err == nil {
+		*err = e
+	}
+}
+
+func Line(table bool) *linewriter.Writer {
+	var options []linewriter.Option
+	if table {
+		options = []linewriter.Option{
+			linewriter.WithSeparator([]byte("|")),
+			linewriter.WithPadding([]byte(" ")),
+		}
+	} else {
+		options = append(options, linewriter.AsCSV(false))
+	}
+	return linewriter.NewWriter(4096, options...)
+}
+
+type closer struct {
+	io.Closer
+}
+
+// closerFrom gives the closer of w. The standard output and error are never
+// closed.
+func closerFrom(w io.Writer) closer {
+	if w == io.Writer(os.Stdout) || w == io.Writer(os.Stderr) {
+		return closer{}
+	}
+	c, _ := w.(io.Closer)
+	return closer{Closer: c}
+}
+
+func (c closer) close(err error) error {
+	if c.Closer == nil {
+		return err
+	}
+	if e := c.Closer.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+type csvDumper struct {
+	closer
+	inner *comma.Writer
+}
+
+func dumpCSV(w io.Writer, sep rune) (Dumper, error) {
+	ws, err := comma.NewWriter(w, comma.WithOutputSeparator(sep))
+	if err != nil {
+		return nil, err
+	}
+	return &csvDumper{closer: closerFrom(w), inner: ws}, nil
+}
+
+func (d *csvDumper) Headers(headers []string) error {
+	return d.inner.Write(headers)
+}
+
+func (d *csvDumper) Dump(row []string) error {
+	return d.inner.Write(row)
+}
+
+func (d *csvDumper) Close() error {
+	return d.close(d.inner.Flush())
+}
+
+type tableDumper struct {
+	closer
+	width int
+	line  *linewriter.Writer
+	inner io.Writer
+}
+
+func dumpTable(w io.Writer, width int) Dumper {
+	return &tableDumper{
+		closer: closerFrom(w),
+		width:  width,
+		line:   Line(true),
+		inner:  w,
+	}
+}
+
+func (d *tableDumper) Headers(headers []string) error {
+	return d.Dump(headers)
+}
+
+func (d *tableDumper) Dump(row []string) error {
+	for i := 0; i < len(row); i++ {
+		d.line.AppendString(row[i], d.width, linewriter.AlignRight)
+	}
+	n, err := io.Copy(d.inner, d.line)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return err
+}
+
+func (d *tableDumper) Close() error {
+	return d.close(nil)
+}
+
+// jsonDumper writes rows as objects keyed by the names of the columns - or by
+// their position when the names are not known.
+type jsonDumper struct {
+	closer
+	inner   *bufio.Writer
+	headers []string
+	lines   bool
+	count   int
+}
+
+func dumpJSON(w io.Writer, lines bool) Dumper {
+	return &jsonDumper{
+		closer: closerFrom(w),
+		inner:  bufio.NewWriter(w),
+		lines:  lines,
+	}
+}
+
+func (d *jsonDumper) Headers(headers []string) error {
+	d.headers = headers
+	return nil
+}
+
+func (d *jsonDumper) Dump(row []string) error {
+	switch {
+	case d.lines:
+	case d.count == 0:
+		d.inner.WriteString("[\n")
+	default:
+		d.inner.WriteString(",\n")
+	}
+	d.count++
+	d.inner.WriteByte('{')
+	for i, v := range row {
+		if i > 0 {
+			d.inner.WriteByte(',')
+		}
+		k, _ := json.Marshal(columnName(d.headers, i))
+		d.inner.Write(k)
+		d.inner.WriteByte(':')
+		v, _ := json.Marshal(v)
+		d.inner.Write(v)
+	}
+	d.inner.WriteByte('}')
+	if d.lines {
+		d.inner.WriteByte('\n')
+	}
+	return nil
+}
+
+func (d *jsonDumper) Close() error {
+	if !d.lines {
+		if d.count == 0 {
+			d.inner.WriteString("[")
+		}
+		d.inner.WriteString("\n]\n")
+	}
+	return d.close(d.inner.Flush())
+}
+
+type markdownDumper struct {
+	closer
+	inner   *bufio.Writer
+	started bool
+}
+
+func dumpMarkdown(w io.Writer) Dumper {
+	return &markdownDumper{
+		closer: closerFrom(w),
+		inner:  bufio.NewWriter(w),
+	}
+}
+
+func (d *markdownDumper) Headers(headers []string) error {
+	d.started = true
+	d.writeRow(headers)
+	d.inner.WriteByte('|')
+	for range headers {
+		d.inner.WriteString(" --- |")
+	}
+	d.inner.WriteByte('\n')
+	return nil
+}
+
+func (d *markdownDumper) Dump(row []string) error {
+	if !d.started {
+		hs := make([]string, len(row))
+		for i := range hs {
+			hs[i] = columnName(nil, i)
+		}
+		d.Headers(hs)
+	}
+	d.writeRow(row)
+	return nil
+}
+
+func (d *markdownDumper) writeRow(row []string) {
+	d.inner.WriteByte('|')
+	for _, v := range row {
+		v = strings.ReplaceAll(v, "|", "\\|")
+		v = strings.ReplaceAll(v, "\n", "<br>")
+		d.inner.WriteString(" " + v + " |")
+	}
+	d.inner.WriteByte('\n')
+}
+
+func (d *markdownDumper) Close() error {
+	return d.close(d.inner.Flush())
+}
+
+type htmlDumper struct {
+	closer
+	inner   *bufio.Writer
+	started bool
+	body    bool
+}
+
+func dumpHTML(w io.Writer) Dumper {
+	return &htmlDumper{
+		closer: closerFrom(w),
+		inner:  bufio.NewWriter(w),
+	}
+}
+
+func (d *htmlDumper) Headers(headers []string) error {
+	d.start()
+	d.inner.WriteString("<thead>\n")
+	d.writeRow(headers, "th")
+	d.inner.WriteString("</thead>\n")
+	return nil
+}
+
+func (d *htmlDumper) Dump(row []string) error {
+	d.start()
+	if !d.body {
+		d.body = true
+		d.inner.WriteString("<tbody>\n")
+	}
+	d.writeRow(row, "td")
+	return nil
+}
+
+func (d *htmlDumper) start() {
+	if d.started {
+		return
+	}
+	d.started = true
+	d.inner.WriteString("<table>\n")
+}
+
+func (d *htmlDumper) writeRow(row []string, cell string) {
+	d.inner.WriteString("<tr>")
+	for _, v := range row {
+		fmt.Fprintf(d.inner, "<%s>%s</%[1]s>", cell, html.EscapeString(v))
+	}
+	d.inner.WriteString("</tr>\n")
+}
+
+func (d *htmlDumper) Close() error {
+	d.start()
+	if d.body {
+		d.inner.WriteString("</tbody>\n")
+	}
+	d.inner.WriteString("</table>\n")
+	return d.close(d.inner.Flush())
+}
+
+type xmlDumper struct {
+	closer
+	inner   *bufio.Writer
+	headers []string
+	started bool
+}
+
+func dumpXML(w io.Writer) Dumper {
+	return &xmlDumper{
+		closer: closerFrom(w),
+		inner:  bufio.NewWriter(w),
+	}
+}
+
+func (d *xmlDumper) Headers(headers []string) error {
+	d.headers = headers
+	return nil
+}
+
+func (d *xmlDumper) Dump(row []string) error {
+	d.start()
+	d.inner.WriteString("  <row>\n")
+	for i, v := range row {
+		d.inner.WriteString("    <column name=\"")
+		xml.EscapeText(d.inner, []byte(columnName(d.headers, i)))
+		d.inner.WriteString("\">")
+		xml.EscapeText(d.inner, []byte(v))
+		d.inner.WriteString("</column>\n")
+	}
+	d.inner.WriteString("  </row>\n")
+	return nil
+}
+
+func (d *xmlDumper) start() {
+	if d.started {
+		return
+	}
+	d.started = true
+	d.inner.WriteString(xml.Header)
+	d.inner.WriteString("<rows>\n")
+}
+
+func (d *xmlDumper) Close() error {
+	d.start()
+	d.inner.WriteString("</rows>\n")
+	return d.close(d.inner.Flush())
+}
+
+func columnName(headers []string, i int) string {
+	if i < len(headers) && headers[i] != "" {
+		return headers[i]
+	}
+	return strconv.Itoa(i + 1)
+}
+
+// formatValue formats the results of the aggregates: numbers with two
+// decimals, the other values as they are.
+func formatValue(v eval.Value) string {
+	if f, ok := v.(eval.Literal); ok {
+		return formatFloat(float64(f))
+	}
+	return v.String()
+}
+
+func textValues(vs []string) []eval.Value {
+	xs := make([]eval.Value, len(vs))
+	for i, v := range vs {
+		xs[i] = eval.Text(v)
+	}
+	return xs
+}
+
+// toFloat gives the value of numeric results - 0 for the others.
+func toFloat(v eval.Value) float64 {
+	f, _ := v.(eval.Literal)
+	return float64(f)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
+
+func formatPercent(f float64) string {
+	return formatFloat(f*100) + "%"
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/midbel/cli"
+	"github.com/midbel/comma"
+	"github.com/midbel/comma/eval"
+)
+
+func runPivot(cmd *cli.Command, args []string) (err error) {
+	o := Options{
+		Separator: Comma(','),
+		Width:     DefaultWidth,
+	}
+	cmd.Flag.Var(&o.Separator, "separator", "separator")
+	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
+	cmd.Flag.StringVar(&o.File, "file", "", "input file")
+	o.registerInput(&cmd.Flag, true)
+	o.registerOutput(&cmd.Flag)
+	cmd.Flag.StringVar(&o.Tag, "tag", "", "tag")
+	order := Order{By: orderKey}
+	cmd.Flag.Var(&order, "order", "order of the rows and of the columns: key or first (appearance)")
+	fill := cmd.Flag.String("fill", "", "value of the cells without rows")
+
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if cmd.Flag.NArg() != 4 {
+		return fmt.Errorf("pivot: expected <row-keys> <column-key> <operation> <value>")
+	}
+	if order.By == orderValue {
+		return fmt.Errorf("pivot: rows can not be ordered by %s", order.By)
+	}
+	r, err := o.Open("", nil)
+	if err != nil {
+		return err
+	}
+	defer o.Close(r)
+
+	headers := r.Headers()
+	rows, err := parseKeys(cmd.Flag.Arg(0), headers)
+	if err != nil {
+		return fmt.Errorf("selection (row): %s", err)
+	}
+	cols, err := parseKeys(cmd.Flag.Arg(1), headers)
+	if err != nil {
+		return fmt.Errorf("selection (column): %s", err)
+	}
+	ops := []string{cmd.Flag.Arg(2), cmd.Flag.Arg(3)}
+	if _, err := parseAggr(ops, headers); err != nil {
+		return err
+	}
+
+	data := Groups{
+		Ops:     ops,
+		Sel:     append(append([]comma.Selection{}, rows...), cols...),
+		Headers: headers,
+		Nulls:   r.Nulls(),
+	}
+	n := -1
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			ks, _ := selectKeys(cols, row)
+			n = len(ks)
+		}
+		if err := data.Upsert(row); err != nil {
+			return err
+		}
+	}
+	p, err := pivotRows(data.Rows(order), n, order.By == orderKey)
+	if err != nil {
+		return err
+	}
+
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeDump(dump, &err)
+
+	hs, _ := selectKeys(rows, headers)
+	if len(hs) == 0 && len(p.Rows) > 0 {
+		hs = make([]string, len(p.Rows[0]))
+	}
+	names := aggrHeaders(ops, headers)
+	for _, c := range p.Columns {
+		if p.Width == 1 {
+			hs = append(hs, c)
+			continue
+		}
+		for i := 0; i < p.Width; i++ {
+			name := strconv.Itoa(i + 1)
+			if i < len(names) {
+				name = names[i]
+			}
+			hs = append(hs, c+"_"+name)
+		}
+	}
+	if err := checkLabels(hs); err != nil {
+		return err
+	}
+	if err := dumpHeaders(dump, hs, o.Tag); err != nil {
+		return err
+	}
+	for i, keys := range p.Rows {
+		var row []string
+		if o.Tag != "" {
+			row = append(row, o.Tag)
+		}
+		row = append(row, keys...)
+		for _, c := range p.Columns {
+			var vs []eval.Value
+			if g, ok := p.Cells[i][c]; ok {
+				vs = g.results()
+			}
+			for j := 0; j < p.Width; j++ {
+				if j < len(vs) {
+					row = append(row, formatValue(vs[j]))
+				} else {
+					row = append(row, *fill)
+				}
+			}
+		}
+		if err := dump.Dump(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pivot is a cross-tab: the groups sharing the same row keys are spread over
+// one cell per value of their column keys.
+type pivot struct {
+	Rows    [][]string
+	Columns []string
+	Cells   []map[string]*Row
+	// Width is the number of results of the aggregate in each cell.
+	Width int
+}
+
+// pivotRows builds the cross-tab of the groups whose n last keys are the
+// column keys. Rows keep the order of the groups. Columns keep it too unless
+// sorted is set, then they are sorted by their keys.
+//
+// The label of a column joins its keys with "_": an error is returned when
+// two different column keys give the same label.
+func pivotRows(rs []*Row, n int, sorted bool) (pivot, error) {
+	var (
+		p      pivot
+		rows   = make(map[string]int)
+		cols   = make(map[string]string)
+		labels = make(map[string]string)
+		keys   [][]string
+	)
+	for _, r := range rs {
+		if n > len(r.Keys) {
+			continue
+		}
+		var (
+			ks  = r.Keys[:len(r.Keys)-n]
+			cs  = r.Keys[len(ks):]
+			key = strings.Join(ks, "\x00")
+		)
+		i, ok := rows[key]
+		if !ok {
+			i = len(p.Rows)
+			rows[key] = i
+			p.Rows = append(p.Rows, ks)
+			p.Cells = append(p.Cells, make(map[string]*Row))
+		}
+		col, ok := cols[strings.Join(cs, "\x00")]
+		if !ok {
+			col = strings.Join(cs, "_")
+			if other, ok := labels[col]; ok {
+				return p, fmt.Errorf("pivot: columns %s and %s have the same label %s", other, strings.Join(cs, ","), col)
+			}
+			cols[strings.Join(cs, "\x00")] = col
+			labels[col] = strings.Join(cs, ",")
+			keys = append(keys, cs)
+		}
+		p.Cells[i][col] = r
+		if w := len(r.results()); w > p.Width {
+			p.Width = w
+		}
+	}
+	if sorted {
+		sort.SliceStable(keys, func(i, j int) bool {
+			return compareKeys(keys[i], keys[j]) < 0
+		})
+	}
+	for _, cs := range keys {
+		p.Columns = append(p.Columns, strings.Join(cs, "_"))
+	}
+	return p, nil
+}
+
+// checkLabels returns an error if a label of the columns of a pivot is found
+// more than once.
+func checkLabels(hs []string) error {
+	seen := make(map[string]struct{})
+	for _, h := range hs {
+		if h == "" {
+			continue
+		}
+		if _, ok := seen[h]; ok {
+			return fmt.Errorf("pivot: %s: duplicate column label", h)
+		}
+		seen[h] = struct{}{}
+	}
+	return nil
+}
+
+func runMelt(cmd *cli.Command, args []string) (err error) {
+	o := Options{
+		Separator: Comma(','),
+		Width:     DefaultWidth,
+	}
+	cmd.Flag.Var(&o.Separator, "separator", "separator")
+	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
+	cmd.Flag.StringVar(&o.File, "file", "", "input file")
+	o.registerInput(&cmd.Flag, true)
+	o.registerOutput(&cmd.Flag)
+	cmd.Flag.StringVar(&o.Tag, "tag", "", "tag")
+	key := cmd.Flag.String("key", "key", "name of the column giving the name of the melted columns")
+	value := cmd.Flag.String("value", "value", "name of the column giving the value of the melted columns")
+	empty := cmd.Flag.Bool("skip-empty", false, "discard the empty values")
+
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if cmd.Flag.NArg() != 2 {
+		return fmt.Errorf("melt: expected <id-columns> <value-columns>")
+	}
+	r, err := o.Open("", nil)
+	if err != nil {
+		return err
+	}
+	defer o.Close(r)
+
+	headers := r.Headers()
+	ids, err := parseKeys(cmd.Flag.Arg(0), headers)
+	if err != nil {
+		return fmt.Errorf("selection (id): %s", err)
+	}
+	values, err := parseKeys(cmd.Flag.Arg(1), headers)
+	if err != nil {
+		return fmt.Errorf("selection (value): %s", err)
+	}
+
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeDump(dump, &err)
+
+	var names []string
+	if len(headers) > 0 {
+		names, _ = selectKeys(values, headers)
+		hs, _ := selectKeys(ids, headers)
+		hs = append(hs, *key, *value)
+		if err := dumpHeaders(dump, hs, o.Tag); err != nil {
+			return err
+		}
+	}
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if names == nil {
+			names = meltNames(values, len(row))
+		}
+		ks, _ := selectKeys(ids, row)
+		vs, _ := selectKeys(values, row)
+		for i, v := range vs {
+			if *empty && v == "" {
+				continue
+			}
+			var line []string
+			if o.Tag != "" {
+				line = append(line, o.Tag)
+			}
+			line = append(line, ks...)
+			if i < len(names) {
+				line = append(line, names[i])
+			} else {
+				line = append(line, "")
+			}
+			line = append(line, v)
+			if err := dump.Dump(line); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// meltNames gives the position of the selected columns when the input has no
+// headers.
+func meltNames(sel []comma.Selection, n int) []string {
+	ps := make([]string, n)
+	for i := range ps {
+		ps[i] = strconv.Itoa(i + 1)
+	}
+	names, _ := selectKeys(sel, ps)
+	return names
+}
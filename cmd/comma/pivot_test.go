@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPivotColumns(t *testing.T) {
+	headers := []string{"g", "c1", "c2", "v"}
+	rows := [][]string{
+		{"x", "r", "1", "1"},
+		{"x", "z", "1", "2"},
+		{"y", "a", "1", "3"},
+		{"x", "a", "2", "4"},
+	}
+	data := []struct {
+		Order   string
+		Cols    string
+		Columns []string
+		Rows    [][]string
+	}{
+		{
+			Order:   orderKey,
+			Cols:    "c1",
+			Columns: []string{"a", "r", "z"},
+			Rows:    [][]string{{"x"}, {"y"}},
+		},
+		{
+			Order:   orderFirst,
+			Cols:    "c1",
+			Columns: []string{"r", "z", "a"},
+			Rows:    [][]string{{"x"}, {"y"}},
+		},
+		{
+			Order:   orderKey,
+			Cols:    "c1,c2",
+			Columns: []string{"a_1", "a_2", "r_1", "z_1"},
+			Rows:    [][]string{{"x"}, {"y"}},
+		},
+	}
+	for _, d := range data {
+		p, err := pivotGroups(headers, rows, "g", d.Cols, d.Order)
+		if err != nil {
+			t.Errorf("%s/%s: unexpected error: %s", d.Order, d.Cols, err)
+			continue
+		}
+		if !reflect.DeepEqual(p.Columns, d.Columns) {
+			t.Errorf("%s/%s: columns mismatched: want %q, got %q", d.Order, d.Cols, d.Columns, p.Columns)
+		}
+		if !reflect.DeepEqual(p.Rows, d.Rows) {
+			t.Errorf("%s/%s: rows mismatched: want %q, got %q", d.Order, d.Cols, d.Rows, p.Rows)
+		}
+	}
+}
+
+func TestPivotLabels(t *testing.T) {
+	headers := []string{"g", "c1", "c2", "v"}
+	rows := [][]string{
+		{"x", "a_b", "", "1"},
+		{"x", "a", "b_", "2"},
+	}
+	if _, err := pivotGroups(headers, rows, "g", "c1,c2", orderKey); err == nil {
+		t.Errorf("columns with the same label should give an error")
+	}
+	if err := checkLabels([]string{"", "", "a_sum", "a_mean"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := checkLabels([]string{"g", "a_sum", "g"}); err == nil {
+		t.Errorf("duplicate labels should give an error")
+	}
+}
+
+func pivotGroups(headers []string, rows [][]string, keys, cols, order string) (pivot, error) {
+	rsel, err := parseKeys(keys, headers)
+	if err != nil {
+		return pivot{}, err
+	}
+	csel, err := parseKeys(cols, headers)
+	if err != nil {
+		return pivot{}, err
+	}
+	data := Groups{
+		Ops:     []string{"sum", "v"},
+		Sel:     append(rsel, csel...),
+		Headers: headers,
+	}
+	for _, r := range rows {
+		if err := data.Upsert(r); err != nil {
+			return pivot{}, err
+		}
+	}
+	return pivotRows(data.Rows(Order{By: order}), len(csel), order == orderKey)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/midbel/cli"
+	"github.com/midbel/comma"
+	"github.com/midbel/comma/eval"
+)
+
+func runWindow(cmd *cli.Command, args []string) (err error) {
+	o := Options{
+		Separator: Comma(','),
+		Width:     DefaultWidth,
+	}
+	cmd.Flag.Var(&o.Separator, "separator", "separator")
+	cmd.Flag.IntVar(&o.Width, "width", o.Width, "column width")
+	cmd.Flag.StringVar(&o.File, "file", "", "input file")
+	o.registerInput(&cmd.Flag, true)
+	o.registerOutput(&cmd.Flag)
+	cmd.Flag.StringVar(&o.Tag, "tag", "", "tag")
+	order := cmd.Flag.String("order", "", "selection of the columns ordering the rows of a partition")
+	specs := cmd.Flag.String("spec", "", "type:order of each column of the order (eg: number:desc,date)")
+
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if cmd.Flag.NArg() < 2 {
+		return fmt.Errorf("window: expected <partition> <function> [<column>]...")
+	}
+	r, err := o.Open("", nil)
+	if err != nil {
+		return err
+	}
+	defer o.Close(r)
+
+	headers := r.Headers()
+	part, err := parseKeys(cmd.Flag.Arg(0), headers)
+	if err != nil {
+		return fmt.Errorf("selection (partition): %s", err)
+	}
+	sel, err := parseKeys(*order, headers)
+	if err != nil {
+		return fmt.Errorf("selection (order): %s", err)
+	}
+	var ss []string
+	if *specs != "" {
+		ss = strings.Split(*specs, ",")
+	}
+	keys, err := comma.ParseSortKeys(sel, ss)
+	if err != nil {
+		return err
+	}
+	fs, err := parseWindow(cmd.Flag.Args()[1:], headers)
+	if err != nil {
+		return err
+	}
+	ws := make([]comma.WindowFunc, len(fs))
+	for i := range fs {
+		ws[i] = fs[i].WindowFunc
+	}
+	win := comma.NewWindow(part, keys, ws...)
+	win.SetNulls(r.Nulls())
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := win.Push(row); err != nil {
+			return err
+		}
+	}
+
+	dump, err := o.Dump(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeDump(dump, &err)
+	if len(headers) > 0 {
+		hs := append([]string{}, headers...)
+		for _, f := range fs {
+			hs = append(hs, f.Name)
+		}
+		if err := dumpHeaders(dump, hs, o.Tag); err != nil {
+			return err
+		}
+	}
+	return win.Each(func(row []string, vs []eval.Value) error {
+		var line []string
+		if o.Tag != "" {
+			line = append(line, o.Tag)
+		}
+		line = append(line, row...)
+		for i, v := range vs {
+			if f, ok := v.(eval.Literal); ok && fs[i].Integer {
+				line = append(line, strconv.FormatFloat(float64(f), 'f', 0, 64))
+			} else {
+				line = append(line, formatValue(v))
+			}
+		}
+		return dump.Dump(line)
+	})
+}
+
+type windowFunc struct {
+	comma.WindowFunc
+	Name    string
+	Integer bool
+}
+
+// parseWindow parses the functions given to the window command. Ranking
+// functions take no column, the other ones are followed by the column they
+// are computed on.
+func parseWindow(vs, headers []string) ([]windowFunc, error) {
+	var fs []windowFunc
+	for i := 0; i < len(vs); i++ {
+		op, arg := strings.ToLower(vs[i]), ""
+		if x := strings.Index(op, ":"); x >= 0 {
+			op, arg = op[:x], op[x+1:]
+		}
+		f := windowFunc{Name: op, Integer: true}
+		switch op {
+		case "row_number", "rank", "dense_rank", "percent_rank":
+			if arg != "" {
+				return nil, fmt.Errorf("%s: unexpected argument", vs[i])
+			}
+		}
+		switch op {
+		case "row_number":
+			f.WindowFunc = comma.RowNumber()
+		case "rank":
+			f.WindowFunc = comma.Rank()
+		case "dense_rank":
+			f.WindowFunc = comma.DenseRank()
+		case "percent_rank":
+			f.WindowFunc, f.Integer = comma.PercentRank(), false
+		default:
+			if i+1 >= len(vs) {
+				return nil, fmt.Errorf("%s: no column given", vs[i])
+			}
+			i++
+			sel, err := parseKeys(vs[i], headers)
+			if err != nil {
+				return nil, err
+			}
+			if len(sel) != 1 {
+				return nil, fmt.Errorf("%s: expected a single column", vs[i])
+			}
+			f.WindowFunc, err = parseColumnWindow(op, arg, sel[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", vs[i-1], err)
+			}
+			f.Integer = false
+			f.Name = strings.ReplaceAll(vs[i-1], ":", "") + "_" + vs[i]
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+func parseColumnWindow(op, arg string, sel comma.Selection) (comma.WindowFunc, error) {
+	n := 1
+	if arg != "" {
+		x, err := strconv.Atoi(arg)
+		if err != nil {
+			return comma.WindowFunc{}, err
+		}
+		n = x
+	}
+	switch op {
+	case "cumsum", "cum_sum":
+		if arg != "" {
+			return comma.WindowFunc{}, fmt.Errorf("unexpected argument")
+		}
+		return comma.CumSum(sel), nil
+	case "mean", "avg", "min", "max":
+		if arg == "" {
+			return comma.WindowFunc{}, fmt.Errorf("number of rows not given")
+		}
+		switch op {
+		case "min":
+			return comma.RollingMin(sel, n)
+		case "max":
+			return comma.RollingMax(sel, n)
+		default:
+			return comma.RollingMean(sel, n)
+		}
+	case "lag":
+		return comma.Lag(sel, n)
+	case "lead":
+		return comma.Lead(sel, n)
+	case "diff", "delta":
+		return comma.Diff(sel, n)
+	default:
+		return comma.WindowFunc{}, fmt.Errorf("unknown function")
+	}
+}
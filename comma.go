@@ -8,87 +8,138 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
 )
 
 var (
-	ErrRange  = errors.New("out of range")
-	ErrEmpty  = errors.New("empty")
-	ErrSyntax = errors.New("invalid syntax")
+	ErrRange   = errors.New("out of range")
+	ErrEmpty   = errors.New("empty")
+	ErrSyntax  = errors.New("invalid syntax")
+	ErrUnknown = errors.New("unknown column")
+	ErrMerge   = errors.New("aggregates can not be merged")
 )
 
+// RowError describes an error that occurred while reading a record. Record
+// is the position of the record in the input (the header line excluded), Line
+// the physical line where it (or the offending value) starts and Column the
+// position of the offending column - starting at 1 like selections. Fields
+// that are not known are left to their zero value.
+type RowError struct {
+	File   string
+	Record int
+	Line   int
+	Column int
+	Name   string
+	Value  string
+	Err    error
+
+	raw         []string
+	recoverable bool
+}
+
+func (e *RowError) Error() string {
+	var str strings.Builder
+	if e.File != "" {
+		str.WriteString(e.File)
+		str.WriteString(":")
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&str, "%d:", e.Line)
+	}
+	if str.Len() > 0 {
+		str.WriteString(" ")
+	}
+	fmt.Fprintf(&str, "record %d", e.Record)
+	if e.Column > 0 {
+		fmt.Fprintf(&str, ", column %d", e.Column)
+		if e.Name != "" {
+			fmt.Fprintf(&str, " (%s)", e.Name)
+		}
+	}
+	if e.Value != "" {
+		fmt.Fprintf(&str, ", value %q", e.Value)
+	}
+	str.WriteString(": ")
+	str.WriteString(e.Err.Error())
+	return str.String()
+}
+
+// reason describes the error without its location.
+func (e *RowError) reason() string {
+	if e.Column == 0 {
+		return e.Err.Error()
+	}
+	name := strconv.Itoa(e.Column)
+	if e.Name != "" {
+		name = e.Name
+	}
+	return fmt.Sprintf("%s: %s", name, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorPolicy tells a Reader what to do with the records it fails to read,
+// format or select.
+type ErrorPolicy int
+
+const (
+	PolicyFail ErrorPolicy = iota
+	PolicySkip
+	PolicyCollect
+)
+
+func ParseErrorPolicy(str string) (ErrorPolicy, error) {
+	switch strings.ToLower(str) {
+	case "", "fail":
+		return PolicyFail, nil
+	case "skip":
+		return PolicySkip, nil
+	case "collect":
+		return PolicyCollect, nil
+	default:
+		return PolicyFail, fmt.Errorf("unknown error policy %s", str)
+	}
+}
+
+func (p ErrorPolicy) String() string {
+	switch p {
+	case PolicySkip:
+		return "skip"
+	case PolicyCollect:
+		return "collect"
+	default:
+		return "fail"
+	}
+}
+
 type Option func(*Reader) error
 
 func WithSeparator(c rune) Option {
 	return func(r *Reader) error {
-		if unicode.IsPunct(c) || c == '|' || c == ' ' || c == '\t' {
-			r.inner.Comma = c
-		} else {
+		if !isSeparator(c) {
 			return fmt.Errorf("invalid separator %c", c)
 		}
+		r.comma = c
 		return nil
 	}
 }
 
+func isSeparator(c rune) bool {
+	return unicode.IsPunct(c) || c == '|' || c == ' ' || c == '\t'
+}
+
 func WithFormatters(specifiers []string) Option {
-	split := func(s string) (string, string, string) {
-		fields := strings.Split(s, ":")
-		for len(fields) < 3 {
-			fields = append(fields, "")
-		}
-		return fields[0], fields[1], fields[2]
-	}
 	return func(r *Reader) error {
-		if len(specifiers) == 0 {
-			return nil
-		}
-		for _, s := range specifiers {
-			col, kind, pattern := split(s)
-			ix, err := strconv.ParseInt(col, 10, 64)
-			if err != nil {
-				return err
-			}
-			ix--
-			if ix < 0 {
-				return ErrRange
-			}
-			f := func(v string) (string, error) {
-				return v, nil
-			}
-			switch strings.ToLower(kind) {
-			case "date":
-				f = formatDate(pattern, []string{"%Y-%m-%d", "%Y/%m/%d", "%Y-%j", "%Y/%j"})
-			case "datetime":
-				f = formatDate(pattern, []string{"%Y-%m-%d %H:%M:%S"})
-			case "timestamp":
-				f = formatTimestamp(pattern)
-			case "duration":
-				f = formatDuration(pattern)
-			case "int":
-				f = formatInt(pattern)
-			case "float", "double", "number":
-				f = formatFloat(pattern)
-			case "bool", "boolean":
-				f = formatBool(pattern)
-			case "string":
-				f = formatString(pattern)
-			case "base64":
-				f = formatBase64(pattern)
-			case "size":
-				f = formatSize(pattern)
-			case "enum":
-				f = formatEnum(pattern)
-			default:
-				return fmt.Errorf("unkown column type %s", kind)
-			}
-			if f == nil {
-				return ErrSyntax
-			}
-			r.formatters = append(r.formatters, formatter{Index: int(ix), Format: f})
+		fs, err := parseFormatters(specifiers)
+		if err == nil {
+			r.formatters = append(r.formatters, fs...)
 		}
-		return nil
+		return err
 	}
 }
 
@@ -105,12 +156,119 @@ func WithSelection(v string) Option {
 	}
 }
 
+// WithHeader tells the Reader that the first record of its input gives the
+// names of the columns. Selections, formatters and filters can then reference
+// columns by their names.
+func WithHeader() Option {
+	return func(r *Reader) error {
+		r.header = true
+		return nil
+	}
+}
+
+// WithErrorPolicy sets the policy of the Reader for the bad records. With
+// PolicyFail (default), Next stops at the first error. With PolicySkip and
+// PolicyCollect, Next goes on with the following records and the rejected
+// records are counted by kind of error (see Rejected). Errors of the
+// underlying input (eg: malformed JSON, I/O errors) are always fatal.
+func WithErrorPolicy(p ErrorPolicy) Option {
+	return func(r *Reader) error {
+		r.policy = p
+		return nil
+	}
+}
+
+// WithRejects gives the writer where the Reader writes, as CSV, the records
+// rejected with PolicyCollect. Each record written starts with its number,
+// its line and the reason of its rejection, followed by its raw fields. The
+// writer is flushed, and closed if it is an io.Closer, when the Reader is
+// closed.
+func WithRejects(w io.Writer) Option {
+	return func(r *Reader) error {
+		ws, err := NewWriter(w)
+		if err == nil {
+			r.rejects, r.rejectsOut = ws, w
+		}
+		return err
+	}
+}
+
+// WithNulls gives the values read as null values (eg: NA, NULL, -) besides
+// the empty value. The cells are not modified: the formatters skip them and
+// the filters, evaluators and aggregates are given the nulls of the Reader
+// (see Nulls).
+func WithNulls(tokens ...string) Option {
+	return func(r *Reader) error {
+		if r.nulls == nil {
+			r.nulls = make(Nulls)
+		}
+		for _, t := range tokens {
+			r.nulls[t] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// Nulls is a set of values read as null values. The empty value is always a
+// null value.
+type Nulls map[string]struct{}
+
+func (n Nulls) IsNull(v string) bool {
+	_, ok := n[v]
+	return ok || strings.TrimSpace(v) == ""
+}
+
+// Mask gives a copy of row where the null values are empty, the null value of
+// the aggregates. row is returned as is when it has no null values to mask.
+func (n Nulls) Mask(row []string) []string {
+	var vs []string
+	for i, v := range row {
+		if _, ok := n[v]; !ok {
+			continue
+		}
+		if vs == nil {
+			vs = append([]string{}, row...)
+		}
+		vs[i] = ""
+	}
+	if vs == nil {
+		return row
+	}
+	return vs
+}
+
+// Tokens gives the values of the set.
+func (n Nulls) Tokens() []string {
+	vs := make([]string, 0, len(n))
+	for v := range n {
+		vs = append(vs, v)
+	}
+	sort.Strings(vs)
+	return vs
+}
+
 type Reader struct {
 	io.Closer
-	inner *csv.Reader
+	inner decoder
+
+	file   string
+	record int
+	comma  rune
+	format string
+	widths []int
+
+	header  bool
+	names   []string
+	headers []string
 
 	indices    []Selection
 	formatters []formatter
+	nulls      Nulls
+
+	policy     ErrorPolicy
+	rejects    *Writer
+	rejectsOut io.Writer
+	rejected   map[string]int
 
 	err error
 }
@@ -120,11 +278,23 @@ func Open(file string, options ...Option) (*Reader, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewReader(f, options...)
+	z, err := Decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	options = append([]Option{withExtension(trimCompressExt(file))}, options...)
+	r, err := NewReader(&compressedFile{ReadCloser: z, file: f}, options...)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	r.file = file
+	return r, nil
 }
 
 func NewReader(r io.Reader, options ...Option) (*Reader, error) {
-	var rs Reader
+	rs := Reader{comma: ','}
 
 	if x, ok := r.(io.Closer); ok {
 		rs.Closer = x
@@ -132,62 +302,311 @@ func NewReader(r io.Reader, options ...Option) (*Reader, error) {
 		rs.Closer = ioutil.NopCloser(r)
 	}
 
-	rb := bufio.NewReader(r)
-	rs.inner = csv.NewReader(rb)
-	rs.inner.TrimLeadingSpace = true
-
 	for _, opt := range options {
 		if err := opt(&rs); err != nil {
 			return nil, err
 		}
 	}
+	dec, err := rs.createDecoder(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	rs.inner = dec
+
+	if x, ok := rs.inner.(namedDecoder); ok {
+		switch names, err := x.Headers(); err {
+		case nil:
+			rs.names = names
+		case io.EOF:
+			rs.err = err
+		default:
+			return nil, err
+		}
+	} else if rs.header {
+		if err := rs.readHeaders(); err != nil {
+			return nil, err
+		}
+	}
+	if err := rs.resolve(); err != nil {
+		return nil, err
+	}
 	return &rs, nil
 }
 
+// Headers returns the names of the columns of the records returned by Next.
+// It returns nil if the Reader has not been created with WithHeader and if
+// its input does not give the names of its columns (json, ndjson).
+func (r *Reader) Headers() []string {
+	return r.headers
+}
+
+// Nulls gives the values read as null values by the Reader.
+func (r *Reader) Nulls() Nulls {
+	return r.nulls
+}
+
+// Rejected gives, by kind of error, the number of records that have been
+// skipped or collected by the Reader.
+func (r *Reader) Rejected() map[string]int {
+	rs := make(map[string]int, len(r.rejected))
+	for k, v := range r.rejected {
+		rs[k] = v
+	}
+	return rs
+}
+
+func (r *Reader) Close() error {
+	var err error
+	if r.rejects != nil {
+		err = r.rejects.Flush()
+		if c, ok := r.rejectsOut.(io.Closer); ok {
+			if e := c.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	if e := r.Closer.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
 func (r *Reader) Err() error {
 	return r.err
 }
 
+// Filter gives the next row matching f. Rows for which f fails are handled
+// by the error policy of the Reader.
 func (r *Reader) Filter(f *Filter) ([]string, error) {
 	for {
 		row, err := r.Next()
+		if err != nil || f == nil {
+			return row, err
+		}
+		ok, err := f.MatchRow(row)
 		if err != nil {
-			return nil, err
+			if err := r.Reject(row, fmt.Errorf("filter: %w", err)); err != nil {
+				return nil, err
+			}
+			continue
 		}
-		if f == nil || f.Match(row) {
+		if ok {
 			return row, nil
 		}
 	}
 }
 
+// Reject applies the error policy of the Reader to an error met by the caller
+// while processing a row returned by Next (eg: an evaluation error). It
+// returns nil when the row is skipped or collected.
+func (r *Reader) Reject(row []string, err error) error {
+	e := r.rowError(row, row, err)
+	if r.policy == PolicyFail {
+		r.err = e
+		return e
+	}
+	if err := r.reject(e.(*RowError)); err != nil {
+		r.err = err
+		return err
+	}
+	return nil
+}
+
 func (r *Reader) Next() ([]string, error) {
-	if r.err != nil {
-		return nil, r.err
+	for {
+		if r.err != nil {
+			return nil, r.err
+		}
+		row, err := r.next()
+		if err == nil || r.policy == PolicyFail || err == io.EOF {
+			return row, err
+		}
+		var e *RowError
+		if !errors.As(err, &e) || !e.recoverable {
+			return nil, err
+		}
+		if err := r.reject(e); err != nil {
+			r.err = err
+		}
 	}
+}
+
+func (r *Reader) next() ([]string, error) {
 	row, err := r.inner.Read()
 	if err != nil {
-		r.err = err
-	} else {
-		if len(r.formatters) > 0 {
-			for _, f := range r.formatters {
-				row[f.Index], err = f.Format(row[f.Index])
-				if err != nil {
-					return nil, err
-				}
-			}
+		if err != io.EOF {
+			r.record++
+			err = r.readError(row, err)
 		}
-		if len(r.indices) > 0 {
-			ds := make([]string, 0, len(r.indices))
-			for _, ix := range r.indices {
-				vs, err := ix.Select(row)
-				if err != nil {
-					r.err = err
-					return nil, r.err
-				}
-				ds = append(ds, vs...)
-			}
-			row = ds
+		if e, ok := err.(*RowError); !ok || !e.recoverable {
+			r.err = err
+		}
+		return nil, err
+	}
+	r.record++
+
+	var raw []string
+	if r.policy != PolicyFail {
+		raw = append(raw, row...)
+	}
+	for _, f := range r.formatters {
+		if f.Index >= len(row) {
+			return nil, r.fieldError(raw, row, f.Index, ErrRange)
+		}
+		if r.nulls.IsNull(row[f.Index]) {
+			continue
+		}
+		v, err := f.Format(row[f.Index])
+		if err != nil {
+			return nil, r.fieldError(raw, row, f.Index, err)
 		}
+		row[f.Index] = v
+	}
+	ds, err := r.selectColumns(row)
+	if err != nil {
+		err = r.rowError(raw, row, err)
+		if r.policy == PolicyFail {
+			r.err = err
+		}
+	}
+	return ds, err
+}
+
+func (r *Reader) reject(e *RowError) error {
+	if r.rejected == nil {
+		r.rejected = make(map[string]int)
+	}
+	r.rejected[errorKind(e.Err)]++
+	if r.policy != PolicyCollect || r.rejects == nil {
+		return nil
+	}
+	row := make([]string, 0, len(e.raw)+3)
+	row = append(row, strconv.Itoa(e.Record), strconv.Itoa(e.Line), e.reason())
+	return r.rejects.Write(append(row, e.raw...))
+}
+
+// errorKind gives the message of the innermost error wrapped by err.
+func errorKind(err error) string {
+	for {
+		e := errors.Unwrap(err)
+		if e == nil {
+			return err.Error()
+		}
+		err = e
+	}
+}
+
+func (r *Reader) selectColumns(row []string) ([]string, error) {
+	if len(r.indices) == 0 {
+		return row, nil
+	}
+	ds := make([]string, 0, len(r.indices))
+	for _, ix := range r.indices {
+		vs, err := ix.Select(row)
+		if err != nil {
+			return nil, fmt.Errorf("selection %s: %w", ix, err)
+		}
+		ds = append(ds, vs...)
+	}
+	return ds, nil
+}
+
+// positioner is implemented by the decoders that know where the fields of
+// the last record read start in their input.
+type positioner interface {
+	FieldPos(int) (int, int)
+}
+
+// readError wraps the errors of the decoder. Only the errors of the csv
+// decoder are recoverable: the others leave their input in an unknown state.
+func (r *Reader) readError(raw []string, err error) error {
+	e := RowError{
+		File:   r.file,
+		Record: r.record,
+		Err:    err,
+		raw:    raw,
+	}
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		e.Line, e.Err = pe.Line, pe.Err
+		e.recoverable = true
+	}
+	if errors.Is(err, ErrUnknown) {
+		e.recoverable = true
+	}
+	return &e
+}
+
+func (r *Reader) rowError(raw, row []string, err error) error {
+	e := RowError{
+		File:        r.file,
+		Record:      r.record,
+		Line:        r.lineOf(row, 0),
+		Err:         err,
+		raw:         raw,
+		recoverable: true,
+	}
+	return &e
+}
+
+func (r *Reader) fieldError(raw, row []string, field int, err error) error {
+	e := RowError{
+		File:        r.file,
+		Record:      r.record,
+		Line:        r.lineOf(row, field),
+		Column:      field + 1,
+		Err:         err,
+		raw:         raw,
+		recoverable: true,
+	}
+	if field < len(r.names) {
+		e.Name = r.names[field]
+	}
+	if field < len(row) {
+		e.Value = row[field]
+	}
+	return &e
+}
+
+func (r *Reader) lineOf(row []string, field int) int {
+	p, ok := r.inner.(positioner)
+	if !ok || len(row) == 0 {
+		return 0
+	}
+	if field >= len(row) {
+		field = 0
+	}
+	line, _ := p.FieldPos(field)
+	return line
+}
+
+func (r *Reader) readHeaders() error {
+	row, err := r.inner.Read()
+	switch err {
+	case nil:
+		r.names = make([]string, len(row))
+		for i := range row {
+			r.names[i] = strings.TrimSpace(row[i])
+		}
+	case io.EOF:
+		r.err = err
+	default:
+		return err
+	}
+	return nil
+}
+
+func (r *Reader) resolve() error {
+	if err := resolveFormatters(r.formatters, r.names); err != nil {
+		return err
+	}
+	cs, err := ResolveSelection(r.indices, r.names)
+	if err != nil {
+		return err
+	}
+	r.indices = cs
+	if len(r.names) > 0 {
+		r.headers, err = r.selectColumns(r.names)
 	}
-	return row, r.err
+	return err
 }
@@ -0,0 +1,186 @@
+package comma
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRowError(t *testing.T) {
+	data := []struct {
+		Input   string
+		Options []Option
+		Want    RowError
+		Err     error
+	}{
+		{
+			Input:   "id,price\n1,10\n2,abc\n",
+			Options: []Option{WithHeader(), WithFormatters([]string{"price:float:%.2f"})},
+			Want:    RowError{Record: 2, Line: 3, Column: 2, Name: "price", Value: "abc"},
+			Err:     strconv.ErrSyntax,
+		},
+		{
+			Input:   "1,2,3\n4,5\n",
+			Options: []Option{},
+			Want:    RowError{Record: 2, Line: 2},
+			Err:     csv.ErrFieldCount,
+		},
+		{
+			Input:   "1,\"2\n\",3\n4,5,6\n",
+			Options: []Option{WithSelection("5")},
+			Want:    RowError{Record: 1, Line: 1},
+			Err:     ErrRange,
+		},
+	}
+	for i, d := range data {
+		r, err := NewReader(strings.NewReader(d.Input), d.Options...)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		for err == nil {
+			_, err = r.Next()
+		}
+		var e *RowError
+		if !errors.As(err, &e) {
+			t.Errorf("%d: expected RowError, got %v", i, err)
+			continue
+		}
+		if !errors.Is(err, d.Err) {
+			t.Errorf("%d: error should wrap %v, got %v", i, d.Err, e.Err)
+		}
+		got := RowError{
+			File:   e.File,
+			Record: e.Record,
+			Line:   e.Line,
+			Column: e.Column,
+			Name:   e.Name,
+			Value:  e.Value,
+		}
+		if !reflect.DeepEqual(got, d.Want) {
+			t.Errorf("%d: error mismatched: want %+v, got %+v", i, d.Want, got)
+		}
+	}
+}
+
+func TestErrorPolicy(t *testing.T) {
+	const input = "id,price\n1,10\n2,abc\n3,4,5\n4,x\n5,20\n"
+	var rejects strings.Builder
+	r, err := NewReader(strings.NewReader(input),
+		WithHeader(),
+		WithFormatters([]string{"price:float:%.1f"}),
+		WithErrorPolicy(PolicyCollect),
+		WithRejects(&rejects),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var ids []string
+	for {
+		row, err := r.Next()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			break
+		}
+		ids = append(ids, row[0])
+	}
+	r.Close()
+	if want := []string{"1", "5"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("rows mismatched: want %q, got %q", want, ids)
+	}
+	want := map[string]int{
+		"invalid syntax":         2,
+		"wrong number of fields": 1,
+	}
+	if got := r.Rejected(); !reflect.DeepEqual(got, want) {
+		t.Errorf("counters mismatched: want %v, got %v", want, got)
+	}
+	wantRejects := "2,3,\"price: strconv.ParseFloat: parsing \"\"abc\"\": invalid syntax\",2,abc\n" +
+		"3,4,wrong number of fields,3,4,5\n" +
+		"4,5,\"price: strconv.ParseFloat: parsing \"\"x\"\": invalid syntax\",4,x\n"
+	if got := rejects.String(); got != wantRejects {
+		t.Errorf("rejects mismatched: want %q, got %q", wantRejects, got)
+	}
+}
+
+func TestReaderNulls(t *testing.T) {
+	const input = "id,price\n1,NA\n2,-\n3,10\n4,\n"
+	r, err := NewReader(strings.NewReader(input),
+		WithHeader(),
+		WithNulls("NA", "-"),
+		WithFormatters([]string{"price:float:%.1f"}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer r.Close()
+
+	f, err := ParseFilterWithHeaders("$price is null", r.Headers(), r.Nulls().Tokens()...)
+	if err != nil {
+		t.Fatalf("fail to parse filter: %s", err)
+	}
+	var rows []string
+	for {
+		row, err := r.Filter(f)
+		if err != nil {
+			if err != io.EOF {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			break
+		}
+		rows = append(rows, strings.Join(row, ","))
+	}
+	if want := []string{"1,NA", "2,-", "4,"}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows mismatched: want %q, got %q", want, rows)
+	}
+	if got := r.Nulls().Mask([]string{"NA", "x", "-"}); !reflect.DeepEqual(got, []string{"", "x", ""}) {
+		t.Errorf("mask mismatched: got %q", got)
+	}
+}
+
+func TestFilterErrorPolicy(t *testing.T) {
+	const input = "1\na\n2\nx\n"
+	for _, p := range []ErrorPolicy{PolicyFail, PolicySkip} {
+		r, err := NewReader(strings.NewReader(input), WithErrorPolicy(p))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		f, err := ParseFilter("$1::number > 0")
+		if err != nil {
+			t.Fatalf("fail to parse filter: %s", err)
+		}
+		var ids []string
+		for {
+			row, err := r.Filter(f)
+			if err != nil {
+				if err != io.EOF && p != PolicyFail {
+					t.Errorf("%s: unexpected error: %s", p, err)
+				}
+				if err == io.EOF && p == PolicyFail {
+					t.Errorf("%s: expected error", p)
+				}
+				break
+			}
+			ids = append(ids, row[0])
+		}
+		if p == PolicySkip {
+			if want := []string{"1", "2"}; !reflect.DeepEqual(ids, want) {
+				t.Errorf("rows mismatched: want %q, got %q", want, ids)
+			}
+			var n int
+			for _, c := range r.Rejected() {
+				n += c
+			}
+			if n != 2 {
+				t.Errorf("rejected rows not counted: want 2, got %d", n)
+			}
+		}
+		r.Close()
+	}
+}
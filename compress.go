@@ -0,0 +1,163 @@
+package comma
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	CompressNone  = ""
+	CompressGzip  = "gzip"
+	CompressBzip2 = "bzip2"
+	CompressZstd  = "zstd"
+	CompressXz    = "xz"
+)
+
+var magics = []struct {
+	Format string
+	Magic  []byte
+}{
+	{Format: CompressGzip, Magic: []byte{0x1f, 0x8b}},
+	{Format: CompressBzip2, Magic: []byte("BZh")},
+	{Format: CompressZstd, Magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{Format: CompressXz, Magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+var extensions = map[string]string{
+	CompressGzip:  ".gz",
+	CompressBzip2: ".bz2",
+	CompressZstd:  ".zst",
+	CompressXz:    ".xz",
+}
+
+// Decompress looks at the first bytes of r and, if they are the magic number
+// of one of the supported compression formats (gzip, bzip2, zstd, xz),
+// returns a reader giving the decompressed stream. Otherwise, the returned
+// reader gives the content of r unchanged.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	rs := bufio.NewReader(r)
+	for _, m := range magics {
+		buf, _ := rs.Peek(len(m.Magic))
+		if !bytes.Equal(buf, m.Magic) {
+			continue
+		}
+		switch m.Format {
+		case CompressGzip:
+			return gzip.NewReader(rs)
+		case CompressBzip2:
+			return ioutil.NopCloser(bzip2.NewReader(rs)), nil
+		case CompressZstd:
+			z, err := zstd.NewReader(rs)
+			if err != nil {
+				return nil, err
+			}
+			return z.IOReadCloser(), nil
+		case CompressXz:
+			z, err := xz.NewReader(rs)
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(z), nil
+		}
+	}
+	return ioutil.NopCloser(rs), nil
+}
+
+// Compress returns a writer compressing with the given format everything
+// written to w. Closing the returned writer flushes the compressed stream and
+// closes w if it is an io.Closer. bzip2 is only supported for reading.
+func Compress(w io.Writer, format string) (io.WriteCloser, error) {
+	var (
+		z   io.WriteCloser
+		err error
+	)
+	switch strings.ToLower(format) {
+	case CompressNone:
+		if c, ok := w.(io.WriteCloser); ok {
+			return c, nil
+		}
+		return nopWriteCloser{w}, nil
+	case CompressGzip, "gz":
+		z = gzip.NewWriter(w)
+	case CompressZstd, "zst":
+		z, err = zstd.NewWriter(w)
+	case CompressXz:
+		z, err = xz.NewWriter(w)
+	default:
+		err = fmt.Errorf("unsupported compression %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &compressWriter{WriteCloser: z, inner: w}, nil
+}
+
+// CompressExt gives the extension of the files compressed with format.
+func CompressExt(format string) string {
+	switch strings.ToLower(format) {
+	case "gz":
+		format = CompressGzip
+	case "zst":
+		format = CompressZstd
+	}
+	return extensions[strings.ToLower(format)]
+}
+
+// trimCompressExt removes the extension of the compression format from the
+// name of file - data.csv.gz gives data.csv.
+func trimCompressExt(file string) string {
+	ext := strings.ToLower(filepath.Ext(file))
+	for _, e := range extensions {
+		if ext == e {
+			return strings.TrimSuffix(file, filepath.Ext(file))
+		}
+	}
+	return file
+}
+
+type compressWriter struct {
+	io.WriteCloser
+	inner io.Writer
+}
+
+func (w *compressWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if c, ok := w.inner.(io.Closer); ok {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// compressedFile closes the decompressor and the file it reads from.
+type compressedFile struct {
+	io.ReadCloser
+	file io.Closer
+}
+
+func (f *compressedFile) Close() error {
+	err := f.ReadCloser.Close()
+	if e := f.file.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package comma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	const data = "id,name\n1,foo\n2,bar\n"
+	dir := t.TempDir()
+	for _, format := range []string{CompressNone, CompressGzip, CompressZstd, CompressXz} {
+		var buf bytes.Buffer
+		w, err := Compress(&buf, format)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", format, err)
+			continue
+		}
+		io.WriteString(w, data)
+		if err := w.Close(); err != nil {
+			t.Errorf("%s: unexpected error: %s", format, err)
+			continue
+		}
+		file := filepath.Join(dir, "data.csv"+CompressExt(format))
+		if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		r, err := Open(file, WithHeader(), WithSelection("name"))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", format, err)
+			continue
+		}
+		var got []string
+		for {
+			row, err := r.Next()
+			if err != nil {
+				if err != io.EOF {
+					t.Errorf("%s: unexpected error: %s", format, err)
+				}
+				break
+			}
+			got = append(got, row...)
+		}
+		r.Close()
+		if len(got) != 2 || got[0] != "foo" || got[1] != "bar" {
+			t.Errorf("%s: unexpected rows %q", format, got)
+		}
+	}
+	if _, err := Compress(ioutil.Discard, CompressBzip2); err == nil {
+		t.Errorf("bzip2: expected error")
+	}
+}
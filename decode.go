@@ -0,0 +1,330 @@
+package comma
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	InputCSV    = "csv"
+	InputTSV    = "tsv"
+	InputJSON   = "json"
+	InputNDJSON = "ndjson"
+	InputFixed  = "fixed"
+)
+
+// decoder is implemented by the types that split the input of a Reader into
+// records.
+type decoder interface {
+	Read() ([]string, error)
+}
+
+// namedDecoder is implemented by the decoders that know the names of the
+// columns of their records without the help of a header line.
+type namedDecoder interface {
+	decoder
+	Headers() ([]string, error)
+}
+
+// WithInput selects the format of the input of the Reader: csv (default),
+// tsv, json, ndjson or fixed. For json and ndjson input, the names of the
+// columns are given by the keys of the first object: the objects having other
+// keys are bad rows (ErrUnknown) handled by the error policy.
+func WithInput(format string) Option {
+	return func(r *Reader) error {
+		switch format = strings.ToLower(format); format {
+		case "", InputCSV:
+		case InputTSV:
+			r.comma = '\t'
+		case InputJSON, InputNDJSON, InputFixed:
+		default:
+			return fmt.Errorf("unknown input format %s", format)
+		}
+		r.format = format
+		return nil
+	}
+}
+
+// WithWidths gives the width (in characters) of each column of a fixed-width
+// input. It implies WithInput(InputFixed).
+func WithWidths(widths []int) Option {
+	return func(r *Reader) error {
+		for _, w := range widths {
+			if w <= 0 {
+				return fmt.Errorf("invalid column width %d", w)
+			}
+		}
+		r.format, r.widths = InputFixed, widths
+		return nil
+	}
+}
+
+// withExtension selects the format of the input from the extension of the
+// file given to Open.
+func withExtension(file string) Option {
+	return func(r *Reader) error {
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".tsv", ".tab":
+			return WithInput(InputTSV)(r)
+		case ".json":
+			return WithInput(InputJSON)(r)
+		case ".ndjson", ".jsonl":
+			return WithInput(InputNDJSON)(r)
+		default:
+			return nil
+		}
+	}
+}
+
+func (r *Reader) createDecoder(rs io.Reader) (decoder, error) {
+	switch r.format {
+	case "", InputCSV, InputTSV:
+		c := csv.NewReader(rs)
+		c.Comma = r.comma
+		c.TrimLeadingSpace = true
+		return c, nil
+	case InputJSON, InputNDJSON:
+		return decodeJSON(rs), nil
+	case InputFixed:
+		if len(r.widths) == 0 {
+			return nil, fmt.Errorf("fixed: no column widths given")
+		}
+		return decodeFixed(rs, r.widths), nil
+	default:
+		return nil, fmt.Errorf("unknown input format %s", r.format)
+	}
+}
+
+// jsonDecoder reads a stream of objects (NDJSON) or an array of objects.
+// Nested objects are flattened: the name of their fields is prefixed by the
+// name of their parent (eg: user.name). Arrays are kept as JSON text.
+type jsonDecoder struct {
+	inner   *json.Decoder
+	array   bool
+	started bool
+
+	names   []string
+	index   map[string]int
+	pending []string
+}
+
+func decodeJSON(r io.Reader) *jsonDecoder {
+	return &jsonDecoder{
+		inner: json.NewDecoder(r),
+		index: make(map[string]int),
+	}
+}
+
+func (d *jsonDecoder) Headers() ([]string, error) {
+	if d.started {
+		return d.names, nil
+	}
+	keys, values, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if _, ok := d.index[k]; ok {
+			continue
+		}
+		d.index[k] = len(d.names)
+		d.names = append(d.names, k)
+	}
+	d.pending, _ = d.arrange(keys, values)
+	return d.names, nil
+}
+
+func (d *jsonDecoder) Read() ([]string, error) {
+	if !d.started {
+		if _, err := d.Headers(); err != nil {
+			return nil, err
+		}
+	}
+	if d.pending != nil {
+		row := d.pending
+		d.pending = nil
+		return row, nil
+	}
+	keys, values, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	return d.arrange(keys, values)
+}
+
+// arrange puts the values in the order of the columns found in the first
+// object. The row is returned with an error if the object has fields unknown
+// in the first object.
+func (d *jsonDecoder) arrange(keys, values []string) ([]string, error) {
+	var (
+		row     = make([]string, len(d.names))
+		unknown []string
+	)
+	for i, k := range keys {
+		if j, ok := d.index[k]; ok {
+			row[j] = values[i]
+		} else {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		return row, fmt.Errorf("json: %s: %w", strings.Join(unknown, ", "), ErrUnknown)
+	}
+	return row, nil
+}
+
+func (d *jsonDecoder) next() ([]string, []string, error) {
+	if !d.started {
+		d.started = true
+		tok, err := d.inner.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		if tok == json.Delim('[') {
+			d.array = true
+		} else if tok != json.Delim('{') {
+			return nil, nil, fmt.Errorf("json: expected object or array, got %v", tok)
+		} else {
+			return d.readObject("")
+		}
+	}
+	if d.array && !d.inner.More() {
+		if _, err := d.inner.Token(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, io.EOF
+	}
+	tok, err := d.inner.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("json: expected object, got %v", tok)
+	}
+	return d.readObject("")
+}
+
+// readObject reads the fields of an object whose opening brace has already
+// been consumed.
+func (d *jsonDecoder) readObject(prefix string) ([]string, []string, error) {
+	var keys, values []string
+	err := flattenObject(d.inner, prefix, func(k, v string) {
+		keys = append(keys, k)
+		values = append(values, v)
+	})
+	return keys, values, err
+}
+
+func flattenObject(dec *json.Decoder, prefix string, fn func(string, string)) error {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("json: expected key, got %v", tok)
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		if err := flattenValue(raw, prefix+key, fn); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+func flattenValue(raw json.RawMessage, key string, fn func(string, string)) error {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		fn(key, "")
+		return nil
+	}
+	switch raw[0] {
+	case '{':
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		return flattenObject(dec, key+".", fn)
+	case '"':
+		var str string
+		if err := json.Unmarshal(raw, &str); err != nil {
+			return err
+		}
+		fn(key, str)
+	case 'n':
+		fn(key, "")
+	case '[':
+		var b bytes.Buffer
+		if err := json.Compact(&b, raw); err != nil {
+			return err
+		}
+		fn(key, b.String())
+	default:
+		fn(key, string(raw))
+	}
+	return nil
+}
+
+// fixedDecoder reads lines where each column has a fixed width. Values are
+// trimmed of their surrounding spaces.
+type fixedDecoder struct {
+	inner  *bufio.Reader
+	widths []int
+
+	line    int
+	columns []int
+}
+
+func decodeFixed(r io.Reader, widths []int) *fixedDecoder {
+	return &fixedDecoder{
+		inner:  bufio.NewReader(r),
+		widths: widths,
+	}
+}
+
+func (d *fixedDecoder) Read() ([]string, error) {
+	line, err := d.inner.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return nil, err
+	}
+	d.line++
+	line = strings.TrimRight(line, "\r\n")
+	row := make([]string, len(d.widths))
+	d.columns = d.columns[:0]
+	for i, w := range d.widths {
+		var n int
+		for j := 0; j < w && n < len(line); j++ {
+			_, z := utf8.DecodeRuneInString(line[n:])
+			n += z
+		}
+		if i == 0 {
+			d.columns = append(d.columns, 1)
+		} else {
+			d.columns = append(d.columns, d.columns[i-1]+d.widths[i-1])
+		}
+		row[i], line = strings.TrimSpace(line[:n]), line[n:]
+	}
+	return row, nil
+}
+
+// FieldPos gives the line and the column (in characters) where the field of
+// the last record read starts.
+func (d *fixedDecoder) FieldPos(field int) (int, int) {
+	if field < 0 || field >= len(d.columns) {
+		return d.line, 0
+	}
+	return d.line, d.columns[field]
+}
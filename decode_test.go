@@ -0,0 +1,110 @@
+package comma
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	data := []struct {
+		Input   string
+		Options []Option
+		Headers []string
+		Rows    [][]string
+	}{
+		{
+			Input:   "{\"id\": 1, \"user\": {\"name\": \"foo\", \"age\": 30}}\n{\"user\": {\"name\": \"bar\"}, \"id\": 2}\n",
+			Options: []Option{WithInput(InputNDJSON)},
+			Headers: []string{"id", "user.name", "user.age"},
+			Rows: [][]string{
+				{"1", "foo", "30"},
+				{"2", "bar", ""},
+			},
+		},
+		{
+			Input:   "[{\"id\": 1, \"tags\": [\"a\", \"b\"], \"note\": null}, {\"id\": 2, \"tags\": [], \"note\": \"x\"}]",
+			Options: []Option{WithInput(InputJSON), WithSelection("note,id")},
+			Headers: []string{"note", "id"},
+			Rows: [][]string{
+				{"", "1"},
+				{"x", "2"},
+			},
+		},
+		{
+			Input:   "id  name      value\n1   foo       10.5\n2   été       -1\n",
+			Options: []Option{WithWidths([]int{4, 10, 5}), WithHeader()},
+			Headers: []string{"id", "name", "value"},
+			Rows: [][]string{
+				{"1", "foo", "10.5"},
+				{"2", "été", "-1"},
+			},
+		},
+		{
+			Input:   "a\tb\n1\t2\n",
+			Options: []Option{WithInput(InputTSV), WithHeader()},
+			Headers: []string{"a", "b"},
+			Rows: [][]string{
+				{"1", "2"},
+			},
+		},
+	}
+	for i, d := range data {
+		r, err := NewReader(strings.NewReader(d.Input), d.Options...)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if got := r.Headers(); !reflect.DeepEqual(got, d.Headers) {
+			t.Errorf("%d: headers mismatched: want %q, got %q", i, d.Headers, got)
+		}
+		var rows [][]string
+		for {
+			row, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%d: unexpected error: %s", i, err)
+			}
+			rows = append(rows, row)
+		}
+		if !reflect.DeepEqual(rows, d.Rows) {
+			t.Errorf("%d: rows mismatched: want %q, got %q", i, d.Rows, rows)
+		}
+	}
+}
+
+func TestDecodeUnknownKeys(t *testing.T) {
+	const input = "{\"id\": 1}\n{\"id\": 2, \"tags\": [1, 2]}\n{\"id\": 3}\n"
+	for _, p := range []ErrorPolicy{PolicyFail, PolicySkip} {
+		r, err := NewReader(strings.NewReader(input), WithInput(InputNDJSON), WithErrorPolicy(p))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var ids []string
+		for {
+			row, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				if p != PolicyFail || !errors.Is(err, ErrUnknown) {
+					t.Errorf("%s: unexpected error: %s", p, err)
+				}
+				break
+			}
+			ids = append(ids, row[0])
+		}
+		want := []string{"1", "3"}
+		if p == PolicyFail {
+			want = want[:1]
+		}
+		if !reflect.DeepEqual(ids, want) {
+			t.Errorf("%s: rows mismatched: want %q, got %q", p, want, ids)
+		}
+		r.Close()
+	}
+}
@@ -0,0 +1,220 @@
+package comma
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"strings"
+
+	"github.com/midbel/comma/eval"
+)
+
+const (
+	// DefaultPrecision is the precision of the HyperLogLog sketches used by
+	// Distinct. A sketch of precision p uses 2^p registers and has a standard
+	// error of 1.04/sqrt(2^p) - 0.8% for the default precision.
+	DefaultPrecision = 14
+
+	minPrecision = 4
+	maxPrecision = 18
+)
+
+type distinct struct {
+	precision int
+	limit     int
+
+	sets     []map[string]struct{}
+	sketches []*hyperloglog
+}
+
+// Distinct counts the number of unique values of each column. The count is
+// exact as long as a column has less than DefaultExactLimit unique values.
+// Past that limit, the count is estimated with a HyperLogLog sketch of
+// DefaultPrecision.
+func Distinct() Aggr {
+	a, _ := DistinctWithPrecision(DefaultPrecision)
+	return a
+}
+
+// DistinctWithPrecision is like Distinct but with sketches of the given
+// precision (between 4 and 18).
+func DistinctWithPrecision(p int) (Aggr, error) {
+	if p < minPrecision || p > maxPrecision {
+		return nil, fmt.Errorf("precision %d: %w", p, ErrRange)
+	}
+	return &distinct{precision: p, limit: DefaultExactLimit}, nil
+}
+
+func (d *distinct) Aggr(vs []string) error {
+	if len(vs) == 0 {
+		return nil
+	}
+	if len(d.sets) == 0 {
+		d.sets = make([]map[string]struct{}, len(vs))
+		d.sketches = make([]*hyperloglog, len(vs))
+		for i := range d.sets {
+			d.sets[i] = make(map[string]struct{})
+		}
+	} else if len(d.sets) != len(vs) {
+		return ErrRange
+	}
+	for i, v := range vs {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if d.sketches[i] != nil {
+			d.sketches[i].Add(v)
+			continue
+		}
+		d.sets[i][v] = struct{}{}
+		if len(d.sets[i]) > d.limit {
+			d.summarize(i)
+		}
+	}
+	return nil
+}
+
+func (d *distinct) Result() []eval.Value {
+	vs := make([]float64, len(d.sets))
+	for i := range d.sets {
+		if d.sketches[i] != nil {
+			vs[i] = math.Round(d.sketches[i].Count())
+		} else {
+			vs[i] = float64(len(d.sets[i]))
+		}
+	}
+	return literals(vs)
+}
+
+func (d *distinct) Merge(a Aggr) error {
+	other, ok := a.(*distinct)
+	if !ok || other.precision != d.precision {
+		return ErrMerge
+	}
+	if len(other.sets) == 0 {
+		return nil
+	}
+	if len(d.sets) == 0 {
+		d.sets = make([]map[string]struct{}, len(other.sets))
+		d.sketches = make([]*hyperloglog, len(other.sets))
+		for i := range d.sets {
+			d.sets[i] = make(map[string]struct{})
+		}
+	} else if len(d.sets) != len(other.sets) {
+		return ErrRange
+	}
+	for i := range other.sets {
+		if other.sketches[i] != nil {
+			if d.sketches[i] == nil {
+				d.summarize(i)
+			}
+			d.sketches[i].Merge(other.sketches[i])
+			continue
+		}
+		for v := range other.sets[i] {
+			if d.sketches[i] != nil {
+				d.sketches[i].Add(v)
+				continue
+			}
+			d.sets[i][v] = struct{}{}
+			if len(d.sets[i]) > d.limit {
+				d.summarize(i)
+			}
+		}
+	}
+	return nil
+}
+
+// summarize moves the values of the i-th column kept so far into a
+// HyperLogLog sketch.
+func (d *distinct) summarize(i int) {
+	d.sketches[i] = newHyperLogLog(d.precision)
+	for v := range d.sets[i] {
+		d.sketches[i].Add(v)
+	}
+	d.sets[i] = nil
+}
+
+type hyperloglog struct {
+	precision uint
+	registers []uint8
+}
+
+func newHyperLogLog(p int) *hyperloglog {
+	return &hyperloglog{
+		precision: uint(p),
+		registers: make([]uint8, 1<<p),
+	}
+}
+
+func (h *hyperloglog) Add(v string) {
+	var (
+		x   = hashString(v)
+		i   = x >> (64 - h.precision)
+		w   = x<<h.precision | 1<<(h.precision-1)
+		rho = uint8(bits.LeadingZeros64(w) + 1)
+	)
+	if rho > h.registers[i] {
+		h.registers[i] = rho
+	}
+}
+
+// Merge sets the registers of the sketch to the maximum of its registers and
+// the registers of other. Both sketches should have the same precision.
+func (h *hyperloglog) Merge(other *hyperloglog) error {
+	if h.precision != other.precision {
+		return fmt.Errorf("hyperloglog: precision mismatched (%d != %d)", h.precision, other.precision)
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+func (h *hyperloglog) Count() float64 {
+	var (
+		m     = float64(len(h.registers))
+		sum   float64
+		zeros int
+	)
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	est := alpha * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+	return est
+}
+
+// hashString hashes v with FNV-1a and mixes the result (splitmix64 finalizer)
+// to spread short and similar values over all the bits of the hash.
+func hashString(v string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(v))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,44 @@
+package comma
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestDistinct(t *testing.T) {
+	data := []struct {
+		Size      int
+		Precision int
+		Error     float64
+	}{
+		{Size: 1000, Precision: DefaultPrecision},
+		{Size: 100000, Precision: DefaultPrecision, Error: 0.03},
+		{Size: 100000, Precision: 10, Error: 0.1},
+	}
+	for _, d := range data {
+		a, err := DistinctWithPrecision(d.Precision)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		for i := 0; i < d.Size; i++ {
+			id := strconv.Itoa(i)
+			if err := a.Aggr([]string{id, id[:1]}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+		got := a.Result()
+		if len(got) != 2 {
+			t.Fatalf("%d values expected, got %d", 2, len(got))
+		}
+		if diff := math.Abs(toFloat(got[0])-float64(d.Size)) / float64(d.Size); diff > d.Error {
+			t.Errorf("distinct(%d): want %d (+/- %.0f%%), got %s", d.Precision, d.Size, d.Error*100, got[0])
+		}
+		if toFloat(got[1]) != 10 {
+			t.Errorf("distinct(%d): want 10, got %s", d.Precision, got[1])
+		}
+	}
+	if _, err := DistinctWithPrecision(2); err == nil {
+		t.Errorf("expected error for precision 2")
+	}
+}
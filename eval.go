@@ -11,7 +11,14 @@ type Filter struct {
 }
 
 func ParseFilter(str string) (*Filter, error) {
-	p, err := eval.Parse(str)
+	return ParseFilterWithHeaders(str, nil)
+}
+
+// ParseFilterWithHeaders parses str as a filter expression. headers, when
+// given, are the names of the columns that the expression can reference by
+// name. The columns equal to one of nulls are null values (see Reader.Nulls).
+func ParseFilterWithHeaders(str string, headers []string, nulls ...string) (*Filter, error) {
+	p, err := eval.ParseWithNulls(str, headers, nulls)
 	if err != nil {
 		return nil, err
 	}
@@ -22,20 +29,29 @@ func ParseFilter(str string) (*Filter, error) {
 	return &Filter{expr: e}, nil
 }
 
+// Match tells if row matches the filter. Rows for which the filter gives a
+// null value or an error do not match.
 func (f Filter) Match(row []string) bool {
+	ok, _ := f.MatchRow(row)
+	return ok
+}
+
+// MatchRow is like Match but returns the errors (eg: a text cast to number)
+// to be handled by the error policy of the caller.
+func (f Filter) MatchRow(row []string) (bool, error) {
 	v, err := f.expr.Value(row)
 	if err != nil {
-		return false
+		return false, err
 	}
 	switch v := v.(type) {
 	case eval.Bool:
-		return bool(v)
+		return bool(v), nil
 	case eval.Literal:
-		return float64(v) != 0
+		return float64(v) != 0, nil
 	case eval.Text:
-		return len(v) != 0
+		return len(v) != 0, nil
 	default:
-		return false
+		return false, nil
 	}
 }
 
@@ -44,9 +60,15 @@ type evaluator struct {
 }
 
 func Eval(sources []string) (eval.Evaluator, error) {
+	return EvalWithHeaders(sources, nil)
+}
+
+// EvalWithHeaders parses the expressions of sources. headers and nulls are
+// used as with ParseFilterWithHeaders.
+func EvalWithHeaders(sources, headers []string, nulls ...string) (eval.Evaluator, error) {
 	es := make([]eval.Evaluator, 0, len(sources))
 	for _, str := range sources {
-		p, err := eval.Parse(str)
+		p, err := eval.ParseWithNulls(str, headers, nulls)
 		if err != nil {
 			return nil, err
 		}
@@ -1,14 +1,23 @@
 package eval
 
 import (
+	"bufio"
 	"fmt"
 	"math"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Function struct {
 	name   string
 	params []Expression
+
+	// pattern is the regular expression given as text to the functions
+	// working with patterns (match, extract, replace).
+	pattern *regexp.Regexp
 }
 
 func (f Function) String() string {
@@ -26,10 +35,59 @@ func (f Function) String() string {
 }
 
 func (f Function) Value(row []string) (Value, error) {
+	if fn, ok := patterns[f.name]; ok {
+		return f.valuePattern(fn, row)
+	}
+	if fn, ok := nullables[f.name]; ok {
+		vs, err := f.values(row)
+		if err != nil {
+			return nil, err
+		}
+		return fn(vs...)
+	}
 	fn, ok := funcs[f.name]
 	if !ok {
 		return nil, fmt.Errorf("function %s not found", f.name)
 	}
+	vs, err := f.values(row)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range vs {
+		if isNull(v) {
+			return Null{}, nil
+		}
+	}
+	return fn(vs...)
+}
+
+func (f Function) valuePattern(fn func(*regexp.Regexp, ...Value) (Value, error), row []string) (Value, error) {
+	vs, err := f.values(row)
+	if err != nil {
+		return nil, err
+	}
+	if len(vs) < 2 {
+		return nil, ErrArgNum
+	}
+	for _, v := range vs {
+		if isNull(v) {
+			return Null{}, nil
+		}
+	}
+	re := f.pattern
+	if re == nil {
+		t, ok := vs[1].(Text)
+		if !ok {
+			return nil, ErrArgType
+		}
+		if re, err = regexp.Compile(string(t)); err != nil {
+			return nil, err
+		}
+	}
+	return fn(re, append(vs[:1:1], vs[2:]...)...)
+}
+
+func (f Function) values(row []string) ([]Value, error) {
 	vs := make([]Value, 0, len(f.params))
 	for _, p := range f.params {
 		v, err := p.Value(row)
@@ -38,7 +96,284 @@ func (f Function) Value(row []string) (Value, error) {
 		}
 		vs = append(vs, v)
 	}
-	return fn(vs...)
+	return vs, nil
+}
+
+// Match tells if the text of left matches the regular expression given by
+// right (=~) or not (!~).
+type Match struct {
+	left  Expression
+	right Expression
+	not   bool
+
+	pattern *regexp.Regexp
+}
+
+func (m Match) String() string {
+	var b strings.Builder
+	b.WriteRune(lparen)
+	b.WriteString(m.left.String())
+	if m.not {
+		b.WriteString(" !~ ")
+	} else {
+		b.WriteString(" =~ ")
+	}
+	b.WriteString(m.right.String())
+	b.WriteRune(rparen)
+	return b.String()
+}
+
+func (m Match) Value(row []string) (Value, error) {
+	left, err := m.left.Value(row)
+	if err != nil || isNull(left) {
+		return left, err
+	}
+	re := m.pattern
+	if re == nil {
+		right, err := m.right.Value(row)
+		if err != nil || isNull(right) {
+			return right, err
+		}
+		if right.Type() != String {
+			return nil, mismatch(match, left.Type(), right.Type())
+		}
+		if re, err = regexp.Compile(right.String()); err != nil {
+			return nil, err
+		}
+	}
+	if left.Type() != String {
+		return nil, mismatch(match, left.Type(), String)
+	}
+	ok := re.MatchString(left.String())
+	if m.not {
+		ok = !ok
+	}
+	return Bool(ok), nil
+}
+
+// In tells if the value of left is one of a set of values (in) or not (not
+// in). Values are compared by their text: numbers are normalized first.
+type In struct {
+	left Expression
+	not  bool
+
+	set    map[string]struct{}
+	values []string
+	file   string
+}
+
+func (i In) String() string {
+	var b strings.Builder
+	b.WriteRune(lparen)
+	b.WriteString(i.left.String())
+	if i.not {
+		b.WriteString(" not")
+	}
+	b.WriteString(" in ")
+	if i.file != "" {
+		b.WriteRune(at)
+		b.WriteString(i.file)
+	} else {
+		b.WriteRune(lparen)
+		b.WriteString(strings.Join(i.values, ","))
+		b.WriteRune(rparen)
+	}
+	b.WriteRune(rparen)
+	return b.String()
+}
+
+func (i In) Value(row []string) (Value, error) {
+	v, err := i.left.Value(row)
+	if err != nil || isNull(v) {
+		return v, err
+	}
+	_, ok := i.set[normalize(v.String())]
+	if i.not {
+		ok = !ok
+	}
+	return Bool(ok), nil
+}
+
+// Between tells if the value of left is between low and high (inclusive).
+type Between struct {
+	left Expression
+	low  Expression
+	high Expression
+	not  bool
+}
+
+func (b Between) String() string {
+	var s strings.Builder
+	s.WriteRune(lparen)
+	s.WriteString(b.left.String())
+	if b.not {
+		s.WriteString(" not")
+	}
+	s.WriteString(" between ")
+	s.WriteString(b.low.String())
+	s.WriteString(" and ")
+	s.WriteString(b.high.String())
+	s.WriteRune(rparen)
+	return s.String()
+}
+
+func (b Between) Value(row []string) (Value, error) {
+	v, err := b.left.Value(row)
+	if err != nil {
+		return nil, err
+	}
+	low, err := b.low.Value(row)
+	if err != nil {
+		return nil, err
+	}
+	high, err := b.high.Value(row)
+	if err != nil {
+		return nil, err
+	}
+	if isNull(v) || isNull(low) || isNull(high) {
+		return Null{}, nil
+	}
+	x, err := evalGreater(v, low, true)
+	if err != nil {
+		return nil, err
+	}
+	y, err := evalLesser(v, high, true)
+	if err != nil {
+		return nil, err
+	}
+	ok := isTrue(x) && isTrue(y)
+	if b.not {
+		ok = !ok
+	}
+	return Bool(ok), nil
+}
+
+// Like tells if the text of left matches a pattern where % matches any
+// sequence of characters and _ any single character.
+type Like struct {
+	left    Expression
+	pattern string
+	not     bool
+
+	re *regexp.Regexp
+}
+
+func (k Like) String() string {
+	var b strings.Builder
+	b.WriteRune(lparen)
+	b.WriteString(k.left.String())
+	if k.not {
+		b.WriteString(" not")
+	}
+	b.WriteString(" like ")
+	b.WriteString(k.pattern)
+	b.WriteRune(rparen)
+	return b.String()
+}
+
+func (k Like) Value(row []string) (Value, error) {
+	v, err := k.left.Value(row)
+	if err != nil || isNull(v) {
+		return v, err
+	}
+	if v.Type() != String {
+		return nil, mismatch(like, v.Type(), String)
+	}
+	ok := k.re.MatchString(v.String())
+	if k.not {
+		ok = !ok
+	}
+	return Bool(ok), nil
+}
+
+func likeToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^(?s)")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// readSet reads the values of a file - one value per line. Empty lines and
+// lines starting with # are skipped.
+func readSet(file string) (map[string]struct{}, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var (
+		set = make(map[string]struct{})
+		s   = bufio.NewScanner(r)
+	)
+	for s.Scan() {
+		txt := strings.TrimSpace(s.Text())
+		if len(txt) == 0 || strings.HasPrefix(txt, "#") {
+			continue
+		}
+		set[normalize(txt)] = struct{}{}
+	}
+	return set, s.Err()
+}
+
+// normalize gives the canonical text of numbers (1.0 and 1 give 1), other
+// values are unchanged.
+func normalize(str string) string {
+	if f, err := strconv.ParseFloat(str, 64); err == nil {
+		return Literal(f).String()
+	}
+	return str
+}
+
+// asText makes the columns referenced without cast to be read as text instead
+// of number.
+func asText(e Expression) Expression {
+	if i, ok := e.(Identifier); ok && i.Cast == "" {
+		i.Cast = "text"
+		return i
+	}
+	return e
+}
+
+// IsNull tells if the value of expr is Null (is null) or not (is not null).
+type IsNull struct {
+	expr Expression
+	not  bool
+}
+
+func (i IsNull) String() string {
+	var b strings.Builder
+	b.WriteRune(lparen)
+	b.WriteString(i.expr.String())
+	if i.not {
+		b.WriteString(" is not null")
+	} else {
+		b.WriteString(" is null")
+	}
+	b.WriteRune(rparen)
+	return b.String()
+}
+
+func (i IsNull) Value(row []string) (Value, error) {
+	v, err := i.expr.Value(row)
+	if err != nil {
+		return nil, err
+	}
+	ok := isNull(v)
+	if i.not {
+		ok = !ok
+	}
+	return Bool(ok), nil
 }
 
 type Infix struct {
@@ -85,10 +420,19 @@ func (x Infix) Value(row []string) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
+	switch {
+	case x.operator == and && !isNull(left) && !isTrue(left):
+		return Bool(false), nil
+	case x.operator == or && isTrue(left):
+		return Bool(true), nil
+	}
 	right, err := x.right.Value(row)
 	if err != nil {
 		return nil, err
 	}
+	if x.operator != and && x.operator != or && (isNull(left) || isNull(right)) {
+		return Null{}, nil
+	}
 	var v Value
 	switch x.operator {
 	default:
@@ -141,8 +485,8 @@ func (x Prefix) String() string {
 
 func (x Prefix) Value(row []string) (Value, error) {
 	v, err := x.right.Value(row)
-	if err != nil {
-		return nil, err
+	if err != nil || isNull(v) {
+		return v, err
 	}
 	switch {
 	default:
@@ -153,6 +497,9 @@ func (x Prefix) Value(row []string) (Value, error) {
 	case x.operator == minus && v.Type() == Number:
 		tmp := v.(Literal)
 		v = Literal(-tmp)
+	case x.operator == minus && v.Type() == Interval:
+		tmp := v.(Duration)
+		v = Duration(-tmp)
 	}
 	return v, nil
 }
@@ -279,8 +626,13 @@ func isTrue(v Value) bool {
 }
 
 func isEqual(left, right Value, not bool) (bool, error) {
+	left, right = coerceTime(left, right)
 	var b bool
-	if left.Type() == Number && right.Type() == Number {
+	if left.Type() == Timestamp && right.Type() == Timestamp {
+		b = time.Time(left.(Time)).Equal(time.Time(right.(Time)))
+	} else if left.Type() == Interval && right.Type() == Interval {
+		b = left.(Duration) == right.(Duration)
+	} else if left.Type() == Number && right.Type() == Number {
 		b = left.(Literal) == right.(Literal)
 	} else if left.Type() == String && right.Type() == String {
 		b = left.(Text) == right.(Text)
@@ -312,7 +664,12 @@ func evalGreater(left, right Value, equal bool) (Value, error) {
 	if equal && b {
 		return Bool(b), nil
 	}
-	if left.Type() == Number && right.Type() == Number {
+	left, right = coerceTime(left, right)
+	if left.Type() == Timestamp && right.Type() == Timestamp {
+		b = time.Time(left.(Time)).After(time.Time(right.(Time)))
+	} else if left.Type() == Interval && right.Type() == Interval {
+		b = left.(Duration) > right.(Duration)
+	} else if left.Type() == Number && right.Type() == Number {
 		b = left.(Literal) > right.(Literal)
 	} else if left.Type() == String && right.Type() == String {
 		b = left.(Text) > right.(Text)
@@ -334,7 +691,12 @@ func evalLesser(left, right Value, equal bool) (Value, error) {
 	if equal && b {
 		return Bool(b), nil
 	}
-	if left.Type() == Number && right.Type() == Number {
+	left, right = coerceTime(left, right)
+	if left.Type() == Timestamp && right.Type() == Timestamp {
+		b = time.Time(left.(Time)).Before(time.Time(right.(Time)))
+	} else if left.Type() == Interval && right.Type() == Interval {
+		b = left.(Duration) < right.(Duration)
+	} else if left.Type() == Number && right.Type() == Number {
 		b = left.(Literal) < right.(Literal)
 	} else if left.Type() == String && right.Type() == String {
 		b = left.(Text) < right.(Text)
@@ -348,24 +710,59 @@ func evalLesser(left, right Value, equal bool) (Value, error) {
 	return Bool(b), nil
 }
 
+// evalAnd and evalOr only get their right operand when the left one does not
+// decide of the result (see Infix.Value). Both give a Bool.
 func evalAnd(left, right Value) (Value, error) {
-	v := isTrue(left) && isTrue(right)
-	return Bool(v), nil
+	switch {
+	case !isNull(left) && !isTrue(left), !isNull(right) && !isTrue(right):
+		return Bool(false), nil
+	case isNull(left) || isNull(right):
+		return Null{}, nil
+	default:
+		return Bool(true), nil
+	}
 }
 
 func evalOr(left, right Value) (Value, error) {
-	if left.Type() == Number && right.Type() == Number {
-		if isTrue(left) {
-			return left, nil
-		} else {
-			return right, nil
+	switch {
+	case isTrue(left) || isTrue(right):
+		return Bool(true), nil
+	case isNull(left) || isNull(right):
+		return Null{}, nil
+	default:
+		return Bool(false), nil
+	}
+}
+
+// coerceTime parses the text compared to a Time as a datetime. The values are
+// unchanged when the text is not a valid datetime.
+func coerceTime(left, right Value) (Value, Value) {
+	parse := func(v Value) Value {
+		if t, ok := v.(Text); ok {
+			if w, err := parseTime(string(t), "datetime", ""); err == nil {
+				return w
+			}
 		}
+		return v
+	}
+	switch {
+	case left.Type() == Timestamp && right.Type() == String:
+		right = parse(right)
+	case left.Type() == String && right.Type() == Timestamp:
+		left = parse(left)
 	}
-	v := isTrue(left) || isTrue(right)
-	return Bool(v), nil
+	return left, right
 }
 
 func evalAdd(left, right Value) (Value, error) {
+	switch {
+	case left.Type() == Timestamp && right.Type() == Interval:
+		return Time(time.Time(left.(Time)).Add(time.Duration(right.(Duration)))), nil
+	case left.Type() == Interval && right.Type() == Timestamp:
+		return Time(time.Time(right.(Time)).Add(time.Duration(left.(Duration)))), nil
+	case left.Type() == Interval && right.Type() == Interval:
+		return left.(Duration) + right.(Duration), nil
+	}
 	if left.Type() == Number && right.Type() == Number {
 		x, y := left.(Literal), right.(Literal)
 		return Literal(x + y), nil
@@ -378,6 +775,14 @@ func evalAdd(left, right Value) (Value, error) {
 }
 
 func evalSubtract(left, right Value) (Value, error) {
+	switch {
+	case left.Type() == Timestamp && right.Type() == Interval:
+		return Time(time.Time(left.(Time)).Add(-time.Duration(right.(Duration)))), nil
+	case left.Type() == Timestamp && right.Type() == Timestamp:
+		return Duration(time.Time(left.(Time)).Sub(time.Time(right.(Time)))), nil
+	case left.Type() == Interval && right.Type() == Interval:
+		return left.(Duration) - right.(Duration), nil
+	}
 	if left.Type() == Number && right.Type() == Number {
 		x, y := left.(Literal), right.(Literal)
 		return Literal(x - y), nil
@@ -398,6 +803,10 @@ func evalMultiply(left, right Value) (Value, error) {
 		x := left.(Text)
 		y := right.(Literal)
 		return Text(strings.Repeat(string(x), int(y))), nil
+	} else if left.Type() == Number && right.Type() == Interval {
+		return Duration(float64(left.(Literal)) * float64(right.(Duration))), nil
+	} else if left.Type() == Interval && right.Type() == Number {
+		return Duration(float64(left.(Duration)) * float64(right.(Literal))), nil
 	} else {
 		return nil, mismatch(multiply, left.Type(), right.Type())
 	}
@@ -4,7 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"regexp"
 	"strings"
+	"time"
+
+	"github.com/midbel/timefmt"
 )
 
 var (
@@ -26,6 +30,107 @@ var funcs = map[string]func(...Value) (Value, error){
 	"min":      min,
 	"max":      max,
 	"avg":      average,
+	"year":     year,
+	"month":    month,
+	"day":      dayOf,
+	"weekday":  weekday,
+	"hour":     hour,
+	"trunc":    trunc,
+	"datediff": datediff,
+	"strftime": strftime,
+	"now":      now,
+}
+
+// patterns are the functions whose second argument is a regular expression.
+// They receive the compiled expression and the other arguments.
+var patterns = map[string]func(*regexp.Regexp, ...Value) (Value, error){
+	"match":   matchPattern,
+	"extract": extract,
+	"replace": replace,
+}
+
+// nullables are the functions called with their Null arguments. The other
+// functions give Null as soon as one of their arguments is Null.
+var nullables = map[string]func(...Value) (Value, error){
+	"coalesce": coalesce,
+	"nullif":   nullif,
+}
+
+func coalesce(vs ...Value) (Value, error) {
+	if len(vs) == 0 {
+		return nil, ErrArgNum
+	}
+	for _, v := range vs {
+		if !isNull(v) {
+			return v, nil
+		}
+	}
+	return Null{}, nil
+}
+
+func nullif(vs ...Value) (Value, error) {
+	if len(vs) != 2 {
+		return nil, ErrArgNum
+	}
+	if isNull(vs[0]) || isNull(vs[1]) {
+		return vs[0], nil
+	}
+	if ok, err := isEqual(vs[0], vs[1], false); err == nil && ok {
+		return Null{}, nil
+	}
+	return vs[0], nil
+}
+
+func matchPattern(re *regexp.Regexp, vs ...Value) (Value, error) {
+	if len(vs) != 1 {
+		return nil, ErrArgNum
+	}
+	t, ok := vs[0].(Text)
+	if !ok {
+		return nil, ErrArgType
+	}
+	return Bool(re.MatchString(string(t))), nil
+}
+
+func extract(re *regexp.Regexp, vs ...Value) (Value, error) {
+	if len(vs) < 1 || len(vs) > 2 {
+		return nil, ErrArgNum
+	}
+	t, ok := vs[0].(Text)
+	if !ok {
+		return nil, ErrArgType
+	}
+	var group int
+	if len(vs) == 2 {
+		i, ok := vs[1].(Literal)
+		if !ok {
+			return nil, ErrArgType
+		}
+		group = int(i)
+	}
+	if group < 0 || group > re.NumSubexp() {
+		return nil, fmt.Errorf("group out of range %d", group)
+	}
+	ms := re.FindStringSubmatch(string(t))
+	if len(ms) == 0 {
+		return Text(""), nil
+	}
+	return Text(ms[group]), nil
+}
+
+func replace(re *regexp.Regexp, vs ...Value) (Value, error) {
+	if len(vs) != 2 {
+		return nil, ErrArgNum
+	}
+	t, ok := vs[0].(Text)
+	if !ok {
+		return nil, ErrArgType
+	}
+	r, ok := vs[1].(Text)
+	if !ok {
+		return nil, ErrArgType
+	}
+	return Text(re.ReplaceAllString(string(t), string(r))), nil
 }
 
 func size(vs ...Value) (Value, error) {
@@ -237,3 +342,137 @@ func average(vs ...Value) (Value, error) {
 	}
 	return m / Literal(len(vs)), nil
 }
+
+// toTime gives the time of a Time or of a text giving a datetime.
+func toTime(v Value) (time.Time, error) {
+	switch x := v.(type) {
+	case Time:
+		return time.Time(x), nil
+	case Text:
+		w, err := parseTime(string(x), "datetime", "")
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Time(w.(Time)), nil
+	default:
+		return time.Time{}, ErrArgType
+	}
+}
+
+func timeField(field func(time.Time) int) func(...Value) (Value, error) {
+	return func(vs ...Value) (Value, error) {
+		if len(vs) != 1 {
+			return nil, ErrArgNum
+		}
+		w, err := toTime(vs[0])
+		if err != nil {
+			return nil, err
+		}
+		return Literal(field(w)), nil
+	}
+}
+
+var (
+	year  = timeField(func(w time.Time) int { return w.Year() })
+	month = timeField(func(w time.Time) int { return int(w.Month()) })
+	dayOf = timeField(func(w time.Time) int { return w.Day() })
+	hour  = timeField(func(w time.Time) int { return w.Hour() })
+	// weekday gives the day of the week from 0 (sunday) to 6 (saturday).
+	weekday = timeField(func(w time.Time) int { return int(w.Weekday()) })
+)
+
+// trunc truncates a time to the start of its year, quarter, month, week
+// (monday), day, hour or minute.
+func trunc(vs ...Value) (Value, error) {
+	if len(vs) != 2 {
+		return nil, ErrArgNum
+	}
+	w, err := toTime(vs[0])
+	if err != nil {
+		return nil, err
+	}
+	unit, ok := vs[1].(Text)
+	if !ok {
+		return nil, ErrArgType
+	}
+	switch strings.ToLower(string(unit)) {
+	case "year":
+		w = time.Date(w.Year(), 1, 1, 0, 0, 0, 0, w.Location())
+	case "quarter":
+		m := w.Month() - (w.Month()-1)%3
+		w = time.Date(w.Year(), m, 1, 0, 0, 0, 0, w.Location())
+	case "month":
+		w = time.Date(w.Year(), w.Month(), 1, 0, 0, 0, 0, w.Location())
+	case "week":
+		w = truncDay(w).AddDate(0, 0, -(int(w.Weekday())+6)%7)
+	case "day":
+		w = truncDay(w)
+	case "hour":
+		w = time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), 0, 0, 0, w.Location())
+	case "minute":
+		w = time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), 0, 0, w.Location())
+	default:
+		return nil, fmt.Errorf("trunc: unknown unit %s", unit)
+	}
+	return Time(w), nil
+}
+
+// datediff gives the difference between two times in days or, when given, in
+// the unit of its third argument (second, minute, hour, day, week).
+func datediff(vs ...Value) (Value, error) {
+	if len(vs) != 2 && len(vs) != 3 {
+		return nil, ErrArgNum
+	}
+	end, err := toTime(vs[0])
+	if err != nil {
+		return nil, err
+	}
+	start, err := toTime(vs[1])
+	if err != nil {
+		return nil, err
+	}
+	unit := day
+	if len(vs) == 3 {
+		u, ok := vs[2].(Text)
+		if !ok {
+			return nil, ErrArgType
+		}
+		switch strings.ToLower(string(u)) {
+		case "second":
+			unit = time.Second
+		case "minute":
+			unit = time.Minute
+		case "hour":
+			unit = time.Hour
+		case "day":
+		case "week":
+			unit = week
+		default:
+			return nil, fmt.Errorf("datediff: unknown unit %s", u)
+		}
+	}
+	return Literal(float64(end.Sub(start)) / float64(unit)), nil
+}
+
+// strftime formats a time with a timefmt pattern.
+func strftime(vs ...Value) (Value, error) {
+	if len(vs) != 2 {
+		return nil, ErrArgNum
+	}
+	w, err := toTime(vs[0])
+	if err != nil {
+		return nil, err
+	}
+	pattern, ok := vs[1].(Text)
+	if !ok {
+		return nil, ErrArgType
+	}
+	return Text(timefmt.Format(w, string(pattern))), nil
+}
+
+func now(vs ...Value) (Value, error) {
+	if len(vs) != 0 {
+		return nil, ErrArgNum
+	}
+	return Time(time.Now()), nil
+}
@@ -2,6 +2,7 @@ package eval
 
 import (
 	"fmt"
+	"strings"
 )
 
 const (
@@ -9,6 +10,7 @@ const (
 	variable
 	env
 	number
+	duration
 	text
 	cast
 	and
@@ -19,6 +21,14 @@ const (
 	lesseq
 	greater
 	greateq
+	match
+	notmatch
+	in
+	between
+	like
+	not
+	file
+	is
 	invalid
 )
 
@@ -48,8 +58,21 @@ const (
 	rcurly    = '}'
 	caret     = '^'
 	comma     = ','
+	tilde     = '~'
+	at        = '@'
 )
 
+// keywords are the words read as operators instead of variables.
+var keywords = map[string]rune{
+	"in":      in,
+	"between": between,
+	"like":    like,
+	"not":     not,
+	"and":     and,
+	"or":      or,
+	"is":      is,
+}
+
 type Token struct {
 	Type    rune
 	Literal string
@@ -67,6 +90,8 @@ func (t Token) String() string {
 		return fmt.Sprintf("<variable(%s)>", t.Literal)
 	case number:
 		return fmt.Sprintf("<literal(%s)>", t.Literal)
+	case duration:
+		return fmt.Sprintf("<duration(%s)>", t.Literal)
 	case cast:
 		return fmt.Sprintf("<cast(%s)>", t.Literal)
 	case env:
@@ -113,6 +138,22 @@ func (t Token) String() string {
 		return "<lesseq>"
 	case greateq:
 		return "<greateq>"
+	case match:
+		return "<match>"
+	case notmatch:
+		return "<notmatch>"
+	case in:
+		return "<in>"
+	case between:
+		return "<between>"
+	case like:
+		return "<like>"
+	case not:
+		return "<not>"
+	case file:
+		return fmt.Sprintf("<file(%s)>", t.Literal)
+	case is:
+		return "<is>"
 	}
 }
 
@@ -148,6 +189,8 @@ func (x *lexer) Next() Token {
 		t.Type = eof
 	case isEnv(x.char):
 		x.readEnv(&t)
+	case x.char == at:
+		x.readFile(&t)
 	case isMath(x.char) || isPunct(x.char):
 		t.Type = rune(x.char)
 	case x.char == langle:
@@ -163,17 +206,25 @@ func (x *lexer) Next() Token {
 			x.readByte()
 		}
 	case x.char == assign:
-		if c := x.peekByte(); c == assign {
+		switch c := x.peekByte(); c {
+		case assign:
 			t.Type = equal
 			x.readByte()
-		} else {
+		case tilde:
+			t.Type = match
+			x.readByte()
+		default:
 			t.Type = rune(x.char)
 		}
 	case x.char == bang:
-		if c := x.peekByte(); c == assign {
+		switch c := x.peekByte(); c {
+		case assign:
 			t.Type = notequal
 			x.readByte()
-		} else {
+		case tilde:
+			t.Type = notmatch
+			x.readByte()
+		default:
 			t.Type = rune(x.char)
 		}
 	case x.char == ampersand:
@@ -224,6 +275,9 @@ func (x *lexer) readText(t *Token) {
 	t.Literal, t.Type = string(x.input[pos:x.pos]), text
 }
 
+// readCast reads the type of a cast optionally followed by a pattern given as
+// text (eg: ::date:"%d/%m/%Y"). The literal of the token is then the type and
+// the pattern separated by a colon.
 func (x *lexer) readCast(t *Token) {
 	x.readByte()
 	x.readByte()
@@ -232,7 +286,21 @@ func (x *lexer) readCast(t *Token) {
 		x.readByte()
 	}
 	t.Literal, t.Type = string(x.input[pos:x.pos]), cast
-	x.unreadByte()
+	if x.char != colon || x.next >= len(x.input) || !isText(x.input[x.next]) {
+		x.unreadByte()
+		return
+	}
+	x.readByte()
+	x.readByte()
+	pos = x.pos
+	for x.char != null && !isText(x.char) {
+		x.readByte()
+	}
+	if x.char == null {
+		t.Type = invalid
+		return
+	}
+	t.Literal += ":" + string(x.input[pos:x.pos])
 }
 
 func (x *lexer) readVariable(t *Token) {
@@ -241,12 +309,36 @@ func (x *lexer) readVariable(t *Token) {
 		x.readByte()
 	}
 	t.Literal, t.Type = string(x.input[pos:x.pos]), variable
+	if k, ok := keywords[strings.ToLower(t.Literal)]; ok {
+		t.Literal, t.Type = "", k
+	}
+	x.unreadByte()
+}
+
+func (x *lexer) readFile(t *Token) {
+	x.readByte()
+	pos := x.pos
+	for x.char != null && x.char != rparen && !isWhitespace(x.char) {
+		x.readByte()
+	}
+	t.Literal, t.Type = string(x.input[pos:x.pos]), file
+	if t.Literal == "" {
+		t.Type = invalid
+	}
 	x.unreadByte()
 }
 
 func (x *lexer) readIndex(t *Token) {
 	x.readByte()
 	pos := x.pos
+	if isVariable(x.char, false) {
+		for isVariable(x.char, true) {
+			x.readByte()
+		}
+		t.Literal, t.Type = string(x.input[pos:x.pos]), index
+		x.unreadByte()
+		return
+	}
 	if x.char == minus {
 		x.readByte()
 	}
@@ -273,6 +365,10 @@ func (x *lexer) readNumber(t *Token) {
 		}
 	}
 	t.Literal, t.Type = string(x.input[pos:x.pos]), number
+	if isUnit(x.char) && (x.next >= len(x.input) || !isVariable(x.input[x.next], true)) {
+		t.Literal, t.Type = string(x.input[pos:x.next]), duration
+		return
+	}
 	x.unreadByte()
 }
 
@@ -308,6 +404,12 @@ func (x *lexer) skipWhitespace() {
 	}
 }
 
+// isUnit tells if x is the unit of a duration: seconds, minutes, hours, days
+// or weeks.
+func isUnit(x byte) bool {
+	return x == 's' || x == 'm' || x == 'h' || x == 'd' || x == 'w'
+}
+
 func isText(x byte) bool {
 	return x == quote
 }
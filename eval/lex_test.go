@@ -100,6 +100,64 @@ func TestLexer(t *testing.T) {
 				{Type: eof},
 			},
 		},
+		{
+			Input: "$name::text == \"foo\" && $1 > 0",
+			Want: []Token{
+				{Type: index, Literal: "name"},
+				{Type: cast, Literal: "text"},
+				{Type: equal},
+				{Type: text, Literal: "foo"},
+				{Type: and},
+				{Type: index, Literal: "1"},
+				{Type: greater},
+				{Type: number, Literal: "0"},
+				{Type: eof},
+			},
+		},
+		{
+			Input: "$1 =~ \"^/api\" || $2 !~ \"err\"",
+			Want: []Token{
+				{Type: index, Literal: "1"},
+				{Type: match},
+				{Type: text, Literal: "^/api"},
+				{Type: or},
+				{Type: index, Literal: "2"},
+				{Type: notmatch},
+				{Type: text, Literal: "err"},
+				{Type: eof},
+			},
+		},
+		{
+			Input: "$1 not in @ids.txt and $2 between 1 and 5",
+			Want: []Token{
+				{Type: index, Literal: "1"},
+				{Type: not},
+				{Type: in},
+				{Type: file, Literal: "ids.txt"},
+				{Type: and},
+				{Type: index, Literal: "2"},
+				{Type: between},
+				{Type: number, Literal: "1"},
+				{Type: and},
+				{Type: number, Literal: "5"},
+				{Type: eof},
+			},
+		},
+		{
+			Input: "$2::date:\"%d/%m/%Y\" + 7d > $3::datetime - 1.5h",
+			Want: []Token{
+				{Type: index, Literal: "2"},
+				{Type: cast, Literal: "date:%d/%m/%Y"},
+				{Type: plus},
+				{Type: duration, Literal: "7d"},
+				{Type: greater},
+				{Type: index, Literal: "3"},
+				{Type: cast, Literal: "datetime"},
+				{Type: minus},
+				{Type: duration, Literal: "1.5h"},
+				{Type: eof},
+			},
+		},
 	}
 	for i, d := range data {
 		x := lex(d.Input)
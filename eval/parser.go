@@ -2,6 +2,7 @@ package eval
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 )
 
@@ -20,7 +21,7 @@ const (
 	bindAssign    // =
 	bindCondition // ?:
 	bindLogical   // &&, ||
-	bindRelation  // ==, !=, <, >, <=, >=
+	bindRelation  // ==, !=, <, >, <=, >=, =~, !~, in, between, like, is
 	bindSum       // +, -
 	bindProduct   // *, /
 	bindPower     // ^
@@ -49,10 +50,19 @@ var bindings = map[rune]int{
 	greater:  bindRelation,
 	lesseq:   bindRelation,
 	greateq:  bindRelation,
+	match:    bindRelation,
+	notmatch: bindRelation,
+	in:       bindRelation,
+	between:  bindRelation,
+	like:     bindRelation,
+	not:      bindRelation,
+	is:       bindRelation,
 }
 
 type Parser struct {
-	lex *lexer
+	lex   *lexer
+	names []string
+	nulls map[string]struct{}
 
 	curr Token
 	peek Token
@@ -63,9 +73,28 @@ type Parser struct {
 }
 
 func Parse(str string) (*Parser, error) {
+	return ParseWithNames(str, nil)
+}
+
+// ParseWithNames creates a Parser where identifiers can also reference
+// columns by their name ($name) - names giving the name of each column.
+func ParseWithNames(str string, names []string) (*Parser, error) {
+	return ParseWithNulls(str, names, nil)
+}
+
+// ParseWithNulls is like ParseWithNames but the columns whose value is one of
+// nulls are also read as Null - as the empty columns are.
+func ParseWithNulls(str string, names, nulls []string) (*Parser, error) {
 	var p Parser
 
 	p.lex = lex(str)
+	p.names = names
+	if len(nulls) > 0 {
+		p.nulls = make(map[string]struct{}, len(nulls))
+		for _, n := range nulls {
+			p.nulls[n] = struct{}{}
+		}
+	}
 	p.infix = map[rune]func(Expression) (Expression, error){
 		plus:     p.parseInfix,
 		minus:    p.parseInfix,
@@ -80,6 +109,13 @@ func Parse(str string) (*Parser, error) {
 		lesseq:   p.parseInfix,
 		greater:  p.parseInfix,
 		greateq:  p.parseInfix,
+		match:    p.parseMatch,
+		notmatch: p.parseMatch,
+		in:       p.parseIn,
+		between:  p.parseBetween,
+		like:     p.parseLike,
+		not:      p.parseNot,
+		is:       p.parseIs,
 		caret:    p.parseInfix,
 		assign:   p.parseAssignInfix,
 		lparen:   p.parseCall,
@@ -90,6 +126,7 @@ func Parse(str string) (*Parser, error) {
 		bang:     p.parsePrefix,
 		index:    p.parseIndex,
 		number:   p.parseValue,
+		duration: p.parseValue,
 		text:     p.parseValue,
 		env:      p.parseValue,
 		variable: p.parseValue,
@@ -151,15 +188,16 @@ func (p *Parser) parseExpression(bp int) (Expression, error) {
 }
 
 func (p *Parser) parseCall(left Expression) (Expression, error) {
-	if p.peek.Type == rparen {
-		return left, nil
-	}
-	p.nextToken()
-
 	fn, ok := left.(Function)
 	if !ok {
 		return nil, fmt.Errorf("parser error: expected <function>, got %T", left)
 	}
+	if p.peek.Type == rparen {
+		p.nextToken()
+		return p.parseCallCast(fn), nil
+	}
+	p.nextToken()
+
 	e, err := p.parseExpression(bindLowest)
 	if err != nil {
 		return nil, err
@@ -179,12 +217,182 @@ func (p *Parser) parseCall(left Expression) (Expression, error) {
 	} else {
 		p.nextToken()
 	}
+	if _, ok := patterns[fn.name]; ok && len(fn.params) >= 2 {
+		fn.params[0] = asText(fn.params[0])
+		if t, ok := fn.params[1].(Text); ok {
+			re, err := regexp.Compile(string(t))
+			if err != nil {
+				return nil, fmt.Errorf("parser error: %s", err)
+			}
+			fn.pattern = re
+		}
+	}
+	return p.parseCallCast(fn), nil
+}
+
+func (p *Parser) parseCallCast(fn Function) Expression {
 	if p.peek.Type == cast {
 		p.nextToken()
-		return castTo(fn, p.curr.Literal), nil
-	} else {
-		return fn, nil
+		return castTo(fn, p.curr.Literal)
+	}
+	return fn
+}
+
+// parseMatch parses the =~ and !~ operators. Patterns given as text are
+// compiled once here; the other ones at each evaluation.
+func (p *Parser) parseMatch(left Expression) (Expression, error) {
+	exp := Match{
+		left: asText(left),
+		not:  p.curr.Type == notmatch,
+	}
+	bp := p.currPower()
+	p.nextToken()
+
+	right, err := p.parseExpression(bp)
+	if err != nil {
+		return nil, err
+	}
+	exp.right = right
+	if t, ok := right.(Text); ok {
+		re, err := regexp.Compile(string(t))
+		if err != nil {
+			return nil, fmt.Errorf("parser error: %s", err)
+		}
+		exp.pattern = re
+	}
+	return exp, nil
+}
+
+// parseNot parses the negation of the in, between and like operators.
+func (p *Parser) parseNot(left Expression) (Expression, error) {
+	var parse func(Expression) (Expression, error)
+	switch p.peek.Type {
+	case in:
+		parse = p.parseIn
+	case between:
+		parse = p.parseBetween
+	case like:
+		parse = p.parseLike
+	default:
+		return nil, fmt.Errorf("parser error: expected in, between or like, got %s", p.peek)
+	}
+	p.nextToken()
+	exp, err := parse(left)
+	if err != nil {
+		return nil, err
+	}
+	switch x := exp.(type) {
+	case In:
+		x.not = true
+		exp = x
+	case Between:
+		x.not = true
+		exp = x
+	case Like:
+		x.not = true
+		exp = x
 	}
+	return exp, nil
+}
+
+// parseIn parses a list of values between parenthesis or the name of a file
+// (@file) giving one value per line. The values are kept in a set.
+func (p *Parser) parseIn(left Expression) (Expression, error) {
+	exp := In{left: asText(left)}
+	p.nextToken()
+	switch p.curr.Type {
+	case file:
+		set, err := readSet(p.curr.Literal)
+		if err != nil {
+			return nil, fmt.Errorf("parser error: %s", err)
+		}
+		exp.set, exp.file = set, p.curr.Literal
+		return exp, nil
+	case lparen:
+	default:
+		return nil, fmt.Errorf("parser error: expected ( or @file, got %s", p.curr)
+	}
+	exp.set = make(map[string]struct{})
+	for p.peek.Type != rparen {
+		p.nextToken()
+		var sign string
+		if p.curr.Type == minus && p.peek.Type == number {
+			sign = "-"
+			p.nextToken()
+		}
+		switch p.curr.Type {
+		case text, number:
+			exp.values = append(exp.values, sign+p.curr.Literal)
+			exp.set[normalize(sign+p.curr.Literal)] = struct{}{}
+		default:
+			return nil, fmt.Errorf("parser error: expected text or number, got %s", p.curr)
+		}
+		switch p.peek.Type {
+		case comma:
+			p.nextToken()
+		case rparen:
+		default:
+			return nil, fmt.Errorf("parser error: expected , or ), got %s", p.peek)
+		}
+	}
+	p.nextToken()
+	return exp, nil
+}
+
+// parseIs parses the is null and is not null operators.
+func (p *Parser) parseIs(left Expression) (Expression, error) {
+	exp := IsNull{expr: left}
+	if p.peek.Type == not {
+		p.nextToken()
+		exp.not = true
+	}
+	if p.peek.Type != variable || p.peek.Literal != "null" {
+		return nil, fmt.Errorf("parser error: expected null, got %s", p.peek)
+	}
+	p.nextToken()
+	return exp, nil
+}
+
+// parseBetween parses the bounds of the between operator. Bounds are
+// separated by the and keyword.
+func (p *Parser) parseBetween(left Expression) (Expression, error) {
+	exp := Between{left: left}
+	p.nextToken()
+
+	low, err := p.parseExpression(bindLogical)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek.Type != and {
+		return nil, fmt.Errorf("parser error: expected and, got %s", p.peek)
+	}
+	p.nextToken()
+	p.nextToken()
+	high, err := p.parseExpression(bindLogical)
+	if err != nil {
+		return nil, err
+	}
+	exp.low, exp.high = low, high
+	return exp, nil
+}
+
+// parseLike parses the like operator. Its pattern should be a text where %
+// matches any sequence of characters and _ any single character.
+func (p *Parser) parseLike(left Expression) (Expression, error) {
+	p.nextToken()
+	if p.curr.Type != text {
+		return nil, fmt.Errorf("parser error: expected text, got %s", p.curr)
+	}
+	exp := Like{
+		left:    asText(left),
+		pattern: p.curr.Literal,
+	}
+	re, err := regexp.Compile(likeToRegexp(p.curr.Literal))
+	if err != nil {
+		return nil, fmt.Errorf("parser error: %s", err)
+	}
+	exp.re = re
+	return exp, nil
 }
 
 func (p *Parser) parseCondition(left Expression) (Expression, error) {
@@ -244,7 +452,9 @@ func (p *Parser) parseValue() (Expression, error) {
 	default:
 		err = fmt.Errorf("parser error: can not parse %s", p.curr)
 	case variable:
-		if lit := p.curr.Literal; lit == "true" || lit == "false" {
+		if lit := p.curr.Literal; lit == "null" {
+			exp = Null{}
+		} else if lit == "true" || lit == "false" {
 			if b, e := strconv.ParseBool(p.curr.Literal); e != nil {
 				err = e
 			} else {
@@ -261,13 +471,19 @@ func (p *Parser) parseValue() (Expression, error) {
 		} else {
 			exp = Literal(f)
 		}
+	case duration:
+		if d, e := parseDuration(p.curr.Literal); e != nil {
+			err = e
+		} else {
+			exp = d
+		}
 	case env:
 		exp = Internal(p.curr.Literal)
 	}
 	if p.peek.Type == cast {
 		p.nextToken()
 		switch exp.(type) {
-		case Bool, Text, Literal:
+		case Bool, Text, Literal, Null:
 			exp = castTo(exp, p.curr.Literal)
 		default:
 			return nil, fmt.Errorf("parser error: %T can not be casted!", exp)
@@ -278,18 +494,33 @@ func (p *Parser) parseValue() (Expression, error) {
 
 func (p *Parser) parseIndex() (Expression, error) {
 	// fmt.Println("-> parseIndex:", p.curr.String())
-	i, err := strconv.ParseInt(p.curr.Literal, 10, 64)
-	if err != nil {
-		return nil, err
+	exp := Identifier{nulls: p.nulls}
+	if i, err := strconv.ParseInt(p.curr.Literal, 10, 64); err == nil {
+		if i == 0 {
+			return nil, fmt.Errorf("parser error: $0: %w", ErrIndex)
+		}
+		exp.Index = int(i)
+	} else if ix := p.lookupName(p.curr.Literal); ix > 0 {
+		exp.Index, exp.Name = ix, p.curr.Literal
+	} else {
+		return nil, fmt.Errorf("parser error: unknown column %s", p.curr.Literal)
 	}
-	exp := Identifier{Index: int(i)}
 	if p.peek.Type == cast {
 		p.nextToken()
-		exp.Cast = p.curr.Literal
+		exp.Cast, exp.Pattern = splitCast(p.curr.Literal)
 	}
 	return exp, nil
 }
 
+func (p *Parser) lookupName(name string) int {
+	for i, n := range p.names {
+		if n == name {
+			return i + 1
+		}
+	}
+	return -1
+}
+
 func (p *Parser) parseGroup() (Expression, error) {
 	// fmt.Println("-> parseGroup:", p.curr.String())
 	p.nextToken()
@@ -345,10 +576,21 @@ func (p *Parser) parseInfix(left Expression) (Expression, error) {
 	p.nextToken()
 
 	right, err := p.parseExpression(bp)
-	if err == nil {
-		exp.right = right
+	if err != nil {
+		return nil, err
 	}
-	return exp, err
+	exp.right = right
+	if exp.operator == equal || exp.operator == notequal {
+		// columns compared to a text are read as text: $1 != "" does not
+		// fail on empty values.
+		if _, ok := exp.right.(Text); ok {
+			exp.left = asText(exp.left)
+		}
+		if _, ok := exp.left.(Text); ok {
+			exp.right = asText(exp.right)
+		}
+	}
+	return exp, nil
 }
 
 func (p *Parser) currPower() int {
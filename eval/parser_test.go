@@ -1,8 +1,12 @@
 package eval
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseValue(t *testing.T) {
@@ -239,6 +243,378 @@ func TestParseAssign(t *testing.T) {
 	}
 }
 
+func TestParseNames(t *testing.T) {
+	names := []string{"name", "age", "city"}
+	data := []struct {
+		Input  string
+		Want   string
+		Values []string
+		Result Value
+	}{
+		{
+			Input:  "$age + 1",
+			Want:   "($age + 1)",
+			Values: []string{"foo", "41", "bar"},
+			Result: Literal(42),
+		},
+		{
+			Input:  "$name::text + $city::text",
+			Want:   "($name::text + $city::text)",
+			Values: []string{"foo", "41", "bar"},
+			Result: Text("foobar"),
+		},
+		{
+			Input:  "$age >= $2",
+			Want:   "($age >= $2)",
+			Values: []string{"foo", "41", "bar"},
+			Result: Bool(true),
+		},
+	}
+	for i, d := range data {
+		p, err := ParseWithNames(d.Input, names)
+		if err != nil {
+			t.Errorf("%d) fail to parse %s: %s", i+1, d.Input, err)
+			continue
+		}
+		e, err := p.ParseExpression()
+		if err != nil {
+			t.Errorf("%d) fail to parse %s: %s", i+1, d.Input, err)
+			continue
+		}
+		if got := e.String(); got != d.Want {
+			t.Errorf("%d) parsing error: want %s, got %s", i+1, d.Want, got)
+			continue
+		}
+		v, err := e.Value(d.Values)
+		if err != nil {
+			t.Errorf("%d) fail to evaluate expression (%s): %s", i+1, d.Input, err)
+			continue
+		}
+		if v != d.Result {
+			t.Errorf("%d) expression badly evaluate: want %s, got %s", i+1, d.Result, v)
+		}
+	}
+	if _, err := parseExpression("$foo + 1"); err == nil {
+		t.Errorf("unknown column name should fail to parse")
+	}
+}
+
+func TestParseMatch(t *testing.T) {
+	data := []struct {
+		Input  string
+		Want   string
+		Values []string
+		Result Value
+	}{
+		{
+			Input:  "$1 =~ \"^/api/v[0-9]+/\"",
+			Want:   "($1::text =~ ^/api/v[0-9]+/)",
+			Values: []string{"/api/v2/users"},
+			Result: Bool(true),
+		},
+		{
+			Input:  "$1 !~ \"timeout\" && $2 > 400",
+			Want:   "(($1::text !~ timeout) && ($2 > 400))",
+			Values: []string{"connection timeout", "500"},
+			Result: Bool(false),
+		},
+		{
+			Input:  "$1 =~ $2::text",
+			Want:   "($1::text =~ $2::text)",
+			Values: []string{"foobar", "o+b"},
+			Result: Bool(true),
+		},
+		{
+			Input:  "match($1::text, \"^[a-z]+$\")",
+			Want:   "match($1::text,^[a-z]+$)",
+			Values: []string{"foo42"},
+			Result: Bool(false),
+		},
+		{
+			Input:  "extract($1, \"user=([a-z]+)\", 1)",
+			Want:   "extract($1::text,user=([a-z]+),1)",
+			Values: []string{"GET /?user=bob&id=1"},
+			Result: Text("bob"),
+		},
+		{
+			Input:  "extract($1, \"[0-9]+\")",
+			Want:   "extract($1::text,[0-9]+)",
+			Values: []string{"no digits"},
+			Result: Text(""),
+		},
+		{
+			Input:  "replace($1, \"([0-9]+)\", \"<$1>\")",
+			Want:   "replace($1::text,([0-9]+),<$1>)",
+			Values: []string{"id 42 and 7"},
+			Result: Text("id <42> and <7>"),
+		},
+	}
+	for i, d := range data {
+		e, err := parseExpression(d.Input)
+		if err != nil {
+			t.Errorf("%d) fail to parse %s: %s", i+1, d.Input, err)
+			continue
+		}
+		if got := e.String(); got != d.Want {
+			t.Errorf("%d) parsing error: want %s, got %s", i+1, d.Want, got)
+			continue
+		}
+		v, err := e.Value(d.Values)
+		if err != nil {
+			t.Errorf("%d) fail to evaluate expression (%s): %s", i+1, d.Input, err)
+			continue
+		}
+		if v != d.Result {
+			t.Errorf("%d) expression badly evaluate: want %s, got %s", i+1, d.Result, v)
+		}
+	}
+	for _, str := range []string{"$1 =~ \"(\"", "match($1::text, \"[a-\")"} {
+		if _, err := parseExpression(str); err == nil {
+			t.Errorf("invalid pattern should fail to parse: %s", str)
+		}
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	data := []struct {
+		Input  string
+		Values []string
+		Result Value
+	}{
+		{Input: "$1 != \"\" && $1::number > 3", Values: []string{""}, Result: Null{}},
+		{Input: "$1 != \"\" && $1::number > 3", Values: []string{"5"}, Result: Bool(true)},
+		{Input: "$1 == \"x\" || $1 > 3", Values: []string{"x"}, Result: Bool(true)},
+		{Input: "$1 == \"\" || $1 > 3", Values: []string{"2"}, Result: Bool(false)},
+		{Input: "0 || 5", Result: Bool(true)},
+		{Input: "0 || 0", Result: Bool(false)},
+		{Input: "1 && \"\"", Result: Bool(false)},
+		{Input: "$1 > 0 ? $1 : $2 + 1", Values: []string{"1", "foo"}, Result: Literal(1)},
+	}
+	for i, d := range data {
+		e, err := parseExpression(d.Input)
+		if err != nil {
+			t.Errorf("%d) fail to parse %s: %s", i+1, d.Input, err)
+			continue
+		}
+		v, err := e.Value(d.Values)
+		if err != nil {
+			t.Errorf("%d) fail to evaluate expression (%s): %s", i+1, d.Input, err)
+			continue
+		}
+		if v != d.Result {
+			t.Errorf("%d) expression badly evaluate (%s): want %s, got %s", i+1, d.Input, d.Result, v)
+		}
+	}
+	e, err := parseExpression("$1 > 0 && $1::number > 3")
+	if err != nil {
+		t.Fatalf("fail to parse: %s", err)
+	}
+	if _, err := e.Value([]string{"foo"}); err == nil {
+		t.Errorf("left operand should still be evaluated")
+	}
+}
+
+func TestNull(t *testing.T) {
+	data := []struct {
+		Input  string
+		Values []string
+		Result Value
+	}{
+		{Input: "$1 is null", Values: []string{""}, Result: Bool(true)},
+		{Input: "$2 is null", Values: []string{"1"}, Result: Bool(true)},
+		{Input: "$1 is not null", Values: []string{"1"}, Result: Bool(true)},
+		{Input: "$1 + 1", Values: []string{""}, Result: Null{}},
+		{Input: "$1 + 1", Values: []string{"  "}, Result: Null{}},
+		{Input: "$1::date > now()", Values: []string{" "}, Result: Null{}},
+		{Input: "$1 > 3", Values: []string{""}, Result: Null{}},
+		{Input: "!($1 == 3)", Values: []string{""}, Result: Null{}},
+		{Input: "$1 > 3 && $2 > 3", Values: []string{"", "1"}, Result: Bool(false)},
+		{Input: "$1 > 3 && $2 > 3", Values: []string{"", "5"}, Result: Null{}},
+		{Input: "$1 > 3 || $2 > 3", Values: []string{"", "5"}, Result: Bool(true)},
+		{Input: "$1 > 3 || $2 > 3", Values: []string{"", "1"}, Result: Null{}},
+		{Input: "$1 in (1, 2)", Values: []string{""}, Result: Null{}},
+		{Input: "$1 like \"a%\"", Values: []string{""}, Result: Null{}},
+		{Input: "coalesce($1, $2, 0)", Values: []string{"", "", "3"}, Result: Literal(0)},
+		{Input: "coalesce($1, $2, 0)", Values: []string{"", "2"}, Result: Literal(2)},
+		{Input: "coalesce($1::text, null)", Values: []string{""}, Result: Null{}},
+		{Input: "nullif($1, 0)", Values: []string{"0"}, Result: Null{}},
+		{Input: "nullif($1, 0)", Values: []string{"1"}, Result: Literal(1)},
+		{Input: "sqrt($1)", Values: []string{""}, Result: Null{}},
+		{Input: "null is null", Result: Bool(true)},
+	}
+	for i, d := range data {
+		e, err := parseExpression(d.Input)
+		if err != nil {
+			t.Errorf("%d) fail to parse %s: %s", i+1, d.Input, err)
+			continue
+		}
+		v, err := e.Value(d.Values)
+		if err != nil {
+			t.Errorf("%d) fail to evaluate expression (%s): %s", i+1, d.Input, err)
+			continue
+		}
+		if v != d.Result {
+			t.Errorf("%d) expression badly evaluate (%s): want %#v, got %#v", i+1, d.Input, d.Result, v)
+		}
+	}
+	for _, str := range []string{"$1 is", "$1 is not 1", "$0 > 1"} {
+		if _, err := parseExpression(str); err == nil {
+			t.Errorf("%s: parsing should fail", str)
+		}
+	}
+	e, err := parseExpression("$-3 > 1")
+	if err != nil {
+		t.Fatalf("fail to parse expression: %s", err)
+	}
+	if _, err := e.Value([]string{"1", "2"}); !errors.Is(err, ErrIndex) {
+		t.Errorf("$-3: expected %s, got %v", ErrIndex, err)
+	}
+}
+
+func TestTime(t *testing.T) {
+	data := []struct {
+		Input  string
+		Values []string
+		Result string
+	}{
+		{Input: "$1::date + 7d", Values: []string{"2020-12-28"}, Result: "2021-01-04"},
+		{Input: "$1::date:\"%d/%m/%Y\" - 1w", Values: []string{"08/03/2021"}, Result: "2021-03-01"},
+		{Input: "$1::datetime + 90m", Values: []string{"2021-03-08 23:00:00"}, Result: "2021-03-09 00:30:00"},
+		{Input: "$2::date - $1::date", Values: []string{"2021-03-01", "2021-03-08"}, Result: "7d"},
+		{Input: "$1::date > \"2021-01-01\"", Values: []string{"2021-03-08"}, Result: "true"},
+		{Input: "$1::date <= \"2021-01-01\"::date", Values: []string{"2021-03-08"}, Result: "false"},
+		{Input: "$1::datetime == $2::date", Values: []string{"2021-03-08T00:00:00", "2021-03-08"}, Result: "true"},
+		{Input: "2 * 1d + 12h", Result: "60h0m0s"},
+		{Input: "year($1::date)", Values: []string{"2021-03-08"}, Result: "2021"},
+		{Input: "month($1::date)", Values: []string{"2021-03-08"}, Result: "3"},
+		{Input: "day($1::date)", Values: []string{"2021-03-08"}, Result: "8"},
+		{Input: "weekday($1::date)", Values: []string{"2021-03-08"}, Result: "1"},
+		{Input: "hour($1::datetime)", Values: []string{"2021-03-08 17:30:00"}, Result: "17"},
+		{Input: "trunc($1::date, \"week\")", Values: []string{"2021-03-14"}, Result: "2021-03-08"},
+		{Input: "trunc($1::date, \"quarter\")", Values: []string{"2021-05-14"}, Result: "2021-04-01"},
+		{Input: "trunc($1::datetime, \"hour\")", Values: []string{"2021-03-08 17:30:00"}, Result: "2021-03-08 17:00:00"},
+		{Input: "datediff($2::date, $1::date)", Values: []string{"2021-03-01", "2021-03-08"}, Result: "7"},
+		{Input: "datediff($2::date, $1::date, \"week\")", Values: []string{"2021-03-01", "2021-03-15"}, Result: "2"},
+		{Input: "strftime($1::date, \"%d/%m/%Y\")", Values: []string{"2021-03-08"}, Result: "08/03/2021"},
+		{Input: "$1::date >= now() - 30d", Values: []string{time.Now().AddDate(0, 0, -5).Format("2006-01-02")}, Result: "true"},
+		{Input: "$1::date >= now() - 30d", Values: []string{"2000-01-01"}, Result: "false"},
+		{Input: "now() - 1d < now()", Result: "true"},
+		{Input: "len(\"\") + 1", Result: "1"},
+		{Input: "year($1::date)", Values: []string{""}, Result: ""},
+	}
+	for i, d := range data {
+		e, err := parseExpression(d.Input)
+		if err != nil {
+			t.Errorf("%d) fail to parse %s: %s", i+1, d.Input, err)
+			continue
+		}
+		v, err := e.Value(d.Values)
+		if err != nil {
+			t.Errorf("%d) fail to evaluate expression (%s): %s", i+1, d.Input, err)
+			continue
+		}
+		if v.String() != d.Result {
+			t.Errorf("%d) expression badly evaluate (%s): want %s, got %s", i+1, d.Input, d.Result, v)
+		}
+	}
+	e, err := parseExpression("$1::date:\"%d/%m/%Y\"")
+	if err != nil {
+		t.Fatalf("fail to parse: %s", err)
+	}
+	if str := e.String(); str != "$1::date:\"%d/%m/%Y\"" {
+		t.Errorf("cast badly printed: %s", str)
+	}
+	if _, err := e.Value([]string{"2021-03-08"}); err == nil {
+		t.Errorf("date not matching the pattern should fail")
+	}
+}
+
+func TestParseSetOperators(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ids.txt")
+	if err := os.WriteFile(file, []byte("# ids\n10\n20.0\n\nabc\n"), 0644); err != nil {
+		t.Fatalf("fail to write ids: %s", err)
+	}
+	data := []struct {
+		Input  string
+		Want   string
+		Values []string
+		Result Value
+	}{
+		{
+			Input:  "$1 in (\"a\", \"b\", \"c\")",
+			Want:   "($1::text in (a,b,c))",
+			Values: []string{"b"},
+			Result: Bool(true),
+		},
+		{
+			Input:  "$1 not in (1, 2.5, -3)",
+			Want:   "($1::text not in (1,2.5,-3))",
+			Values: []string{"-3.0"},
+			Result: Bool(false),
+		},
+		{
+			Input:  "$1 in @" + file,
+			Want:   "($1::text in @" + file + ")",
+			Values: []string{"20"},
+			Result: Bool(true),
+		},
+		{
+			Input:  "$1 in @" + file + " && $2 > 0",
+			Want:   "(($1::text in @" + file + ") && ($2 > 0))",
+			Values: []string{"30", "1"},
+			Result: Bool(false),
+		},
+		{
+			Input:  "$1 between 10 and 20 && $2 == 1",
+			Want:   "(($1 between 10 and 20) && ($2 == 1))",
+			Values: []string{"20", "1"},
+			Result: Bool(true),
+		},
+		{
+			Input:  "$1 not between 10 and 5 * 4",
+			Want:   "($1 not between 10 and (5 * 4))",
+			Values: []string{"9"},
+			Result: Bool(true),
+		},
+		{
+			Input:  "$1 like \"abc%\"",
+			Want:   "($1::text like abc%)",
+			Values: []string{"abcdef"},
+			Result: Bool(true),
+		},
+		{
+			Input:  "$1 not like \"a_c.%\"",
+			Want:   "($1::text not like a_c.%)",
+			Values: []string{"abcxlog"},
+			Result: Bool(true),
+		},
+	}
+	for i, d := range data {
+		e, err := parseExpression(d.Input)
+		if err != nil {
+			t.Errorf("%d) fail to parse %s: %s", i+1, d.Input, err)
+			continue
+		}
+		if got := e.String(); got != d.Want {
+			t.Errorf("%d) parsing error: want %s, got %s", i+1, d.Want, got)
+			continue
+		}
+		v, err := e.Value(d.Values)
+		if err != nil {
+			t.Errorf("%d) fail to evaluate expression (%s): %s", i+1, d.Input, err)
+			continue
+		}
+		if v != d.Result {
+			t.Errorf("%d) expression badly evaluate: want %s, got %s", i+1, d.Result, v)
+		}
+	}
+	for _, str := range []string{"$1 in ($2)", "$1 between 1", "$1 like 10", "$1 not == 2", "$1 in @nofile.txt"} {
+		if _, err := parseExpression(str); err == nil {
+			t.Errorf("invalid expression should fail to parse: %s", str)
+		}
+	}
+}
+
 func parseExpression(str string) (Expression, error) {
 	p, err := Parse(str)
 	if err != nil {
@@ -8,6 +8,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/midbel/timefmt"
 )
 
 func init() {
@@ -67,6 +69,10 @@ func (e TypeError) Error() string {
 		return fmt.Sprintf("type mismatch %s > %s", e.left, e.right)
 	case greateq:
 		return fmt.Sprintf("type mismatch %s >= %s", e.left, e.right)
+	case match:
+		return fmt.Sprintf("type mismatch %s =~ %s", e.left, e.right)
+	case like:
+		return fmt.Sprintf("type mismatch %s like %s", e.left, e.right)
 	default:
 		return fmt.Sprintf("type mismatch %s %c %s", e.left, e.op, e.right)
 	}
@@ -79,6 +85,9 @@ const (
 	Number
 	String
 	Boolean
+	Nil
+	Timestamp
+	Interval
 )
 
 func (t Type) String() string {
@@ -89,6 +98,12 @@ func (t Type) String() string {
 		return "string"
 	case Boolean:
 		return "boolean"
+	case Nil:
+		return "null"
+	case Timestamp:
+		return "datetime"
+	case Interval:
+		return "duration"
 	default:
 		return "unknown"
 	}
@@ -100,12 +115,23 @@ type Value interface {
 }
 
 type Cast struct {
-	Cast  string
-	Inner Expression
+	Cast    string
+	Pattern string
+	Inner   Expression
 }
 
 func castTo(e Expression, typ string) Expression {
-	return Cast{Cast: typ, Inner: e}
+	c := Cast{Inner: e}
+	c.Cast, c.Pattern = splitCast(typ)
+	return c
+}
+
+// splitCast gives the type and the pattern of a cast (type:pattern).
+func splitCast(str string) (string, string) {
+	if x := strings.Index(str, ":"); x >= 0 {
+		return str[:x], str[x+1:]
+	}
+	return str, ""
 }
 
 func (c Cast) Type() Type {
@@ -116,6 +142,8 @@ func (c Cast) Type() Type {
 		return String
 	case "boolean":
 		return Boolean
+	case "date", "datetime":
+		return Timestamp
 	default:
 		return unknown
 	}
@@ -123,7 +151,7 @@ func (c Cast) Type() Type {
 
 func (c Cast) Value(row []string) (Value, error) {
 	v, err := c.Inner.Value(row)
-	if err == nil {
+	if err == nil && !isNull(v) {
 		switch c.Type() {
 		default:
 			return nil, failtocast(c.Cast, c.String())
@@ -155,6 +183,21 @@ func (c Cast) Value(row []string) (Value, error) {
 			}
 		case String:
 			v = Text(v.String())
+		case Timestamp:
+			switch x := v.(type) {
+			case Time:
+				if c.Cast == "date" {
+					v = Time(truncDay(time.Time(x)))
+				}
+			case Literal:
+				v = Time(time.Unix(int64(x), 0).UTC())
+			case Text:
+				if v, err = parseTime(string(x), c.Cast, c.Pattern); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, failtocast(c.Cast, v.String())
+			}
 		}
 	}
 	return v, err
@@ -166,10 +209,21 @@ func (c Cast) String() string {
 	b.WriteRune(colon)
 	b.WriteRune(colon)
 	b.WriteString(c.Cast)
+	writePattern(&b, c.Pattern)
 
 	return b.String()
 }
 
+func writePattern(b *strings.Builder, pattern string) {
+	if pattern == "" {
+		return
+	}
+	b.WriteRune(colon)
+	b.WriteRune(quote)
+	b.WriteString(pattern)
+	b.WriteRune(quote)
+}
+
 type Literal float64
 
 func (i Literal) Type() Type                      { return Number }
@@ -188,6 +242,110 @@ func (b Bool) Type() Type                      { return Boolean }
 func (b Bool) String() string                  { return strconv.FormatBool(bool(b)) }
 func (b Bool) Value(_ []string) (Value, error) { return b, nil }
 
+// Null is the value of the empty cells and of the cells missing in a row.
+// Operators and functions given a Null give Null, except the logical
+// operators that follow the rules of the three-valued logic: false && null is
+// false and true || null is true.
+type Null struct{}
+
+func (n Null) Type() Type                      { return Nil }
+func (n Null) String() string                  { return "" }
+func (n Null) Value(_ []string) (Value, error) { return n, nil }
+
+func isNull(v Value) bool {
+	return v.Type() == Nil
+}
+
+// Time is a date or a datetime. Dates are times at midnight.
+type Time time.Time
+
+func (t Time) Type() Type { return Timestamp }
+func (t Time) String() string {
+	w := time.Time(t)
+	if w.Equal(truncDay(w)) {
+		return w.Format("2006-01-02")
+	}
+	return w.Format("2006-01-02 15:04:05")
+}
+func (t Time) Value(_ []string) (Value, error) { return t, nil }
+
+// Duration is the difference between two Times. Duration literals are
+// numbers followed by their unit: s, m, h, d (days) or w (weeks).
+type Duration time.Duration
+
+func (d Duration) Type() Type { return Interval }
+func (d Duration) String() string {
+	if d != 0 && d%Duration(day) == 0 {
+		return strconv.FormatInt(int64(d/Duration(day)), 10) + "d"
+	}
+	return time.Duration(d).String()
+}
+func (d Duration) Value(_ []string) (Value, error) { return d, nil }
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+)
+
+func parseDuration(str string) (Duration, error) {
+	if str == "" {
+		return 0, fmt.Errorf("invalid duration")
+	}
+	var unit time.Duration
+	switch str[len(str)-1] {
+	case 's':
+		unit = time.Second
+	case 'm':
+		unit = time.Minute
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = day
+	case 'w':
+		unit = week
+	default:
+		return 0, fmt.Errorf("%s: unknown unit", str)
+	}
+	f, err := strconv.ParseFloat(str[:len(str)-1], 64)
+	if err != nil {
+		return 0, err
+	}
+	return Duration(f * float64(unit)), nil
+}
+
+var (
+	datePatterns     = []string{"%Y-%m-%d", "%Y/%m/%d", "%Y-%j", "%Y/%j"}
+	datetimePatterns = []string{"%Y-%m-%d %H:%M:%S", "%Y-%m-%dT%H:%M:%S"}
+)
+
+// parseTime parses str as a date (cast date) or a datetime (cast datetime)
+// with pattern or, when not given, with the default patterns of the cast.
+// Datetimes are also parsed with the patterns of the dates.
+func parseTime(str, cast, pattern string) (Value, error) {
+	ps := datePatterns
+	if cast == "datetime" {
+		ps = append(append([]string{}, datetimePatterns...), datePatterns...)
+	}
+	if pattern != "" {
+		ps = []string{pattern}
+	}
+	for _, p := range ps {
+		w, err := timefmt.Parse(strings.TrimSpace(str), p)
+		if err != nil {
+			continue
+		}
+		if cast == "date" {
+			w = truncDay(w)
+		}
+		return Time(w), nil
+	}
+	return nil, failtocast(cast, str)
+}
+
+func truncDay(w time.Time) time.Time {
+	return time.Date(w.Year(), w.Month(), w.Day(), 0, 0, 0, 0, w.Location())
+}
+
 type Internal string
 
 func (i Internal) Type() Type {
@@ -229,22 +387,38 @@ func (i Internal) Value(_ []string) (Value, error) {
 }
 
 type Identifier struct {
-	Index int
-	Cast  string
+	Index   int
+	Name    string
+	Cast    string
+	Pattern string
+
+	nulls map[string]struct{}
 }
 
 func (i Identifier) String() string {
 	var b strings.Builder
 	b.WriteString("$")
-	b.WriteString(strconv.FormatInt(int64(i.Index), 10))
+	if i.Name != "" {
+		b.WriteString(i.Name)
+	} else {
+		b.WriteString(strconv.FormatInt(int64(i.Index), 10))
+	}
 	if i.Cast != "" {
 		b.WriteRune(colon)
 		b.WriteRune(colon)
 		b.WriteString(i.Cast)
+		writePattern(&b, i.Pattern)
 	}
 	return b.String()
 }
 
+// isNull tells if str is a null value: one of the null tokens or a blank cell,
+// like the null values of the aggregates.
+func (i Identifier) isNull(str string) bool {
+	_, ok := i.nulls[str]
+	return ok || strings.TrimSpace(str) == ""
+}
+
 func (i Identifier) Value(row []string) (Value, error) {
 	x := i.Index
 	if x < 0 {
@@ -252,13 +426,16 @@ func (i Identifier) Value(row []string) (Value, error) {
 	} else {
 		x--
 	}
-	if x < 0 || x >= len(row) {
+	if x < 0 {
 		return nil, ErrIndex
 	}
+	if x >= len(row) || i.isNull(row[x]) {
+		return Null{}, nil
+	}
 	switch i.Cast {
 	default:
 		return nil, failtocast(i.Cast, row[x])
-	case "", "float", "int":
+	case "", "float", "int", "number":
 		f, err := strconv.ParseFloat(row[x], 64)
 		if err != nil {
 			return nil, failtocast(i.Cast, row[x])
@@ -272,5 +449,7 @@ func (i Identifier) Value(row []string) (Value, error) {
 		return Bool(b), nil
 	case "text":
 		return Text(row[x]), nil
+	case "date", "datetime":
+		return parseTime(row[x], i.Cast, i.Pattern)
 	}
 }
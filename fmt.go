@@ -17,9 +17,86 @@ import (
 
 type formatter struct {
 	Index  int
+	Name   string
 	Format func(string) (string, error)
 }
 
+func parseFormatters(specifiers []string) ([]formatter, error) {
+	split := func(s string) (string, string, string) {
+		fields := strings.Split(s, ":")
+		for len(fields) < 3 {
+			fields = append(fields, "")
+		}
+		return fields[0], fields[1], fields[2]
+	}
+	var fs []formatter
+	for _, s := range specifiers {
+		col, kind, pattern := split(s)
+		var (
+			name string
+			ix   int64
+		)
+		if i, err := strconv.ParseInt(col, 10, 64); err == nil {
+			ix = i - 1
+			if ix < 0 {
+				return nil, ErrRange
+			}
+		} else if col != "" {
+			name = col
+		} else {
+			return nil, ErrSyntax
+		}
+		f := func(v string) (string, error) {
+			return v, nil
+		}
+		switch strings.ToLower(kind) {
+		case "date":
+			f = formatDate(pattern, []string{"%Y-%m-%d", "%Y/%m/%d", "%Y-%j", "%Y/%j"})
+		case "datetime":
+			f = formatDate(pattern, []string{"%Y-%m-%d %H:%M:%S"})
+		case "timestamp":
+			f = formatTimestamp(pattern)
+		case "duration":
+			f = formatDuration(pattern)
+		case "int":
+			f = formatInt(pattern)
+		case "float", "double", "number":
+			f = formatFloat(pattern)
+		case "bool", "boolean":
+			f = formatBool(pattern)
+		case "string":
+			f = formatString(pattern)
+		case "base64":
+			f = formatBase64(pattern)
+		case "size":
+			f = formatSize(pattern)
+		case "enum":
+			f = formatEnum(pattern)
+		default:
+			return nil, fmt.Errorf("unkown column type %s", kind)
+		}
+		if f == nil {
+			return nil, ErrSyntax
+		}
+		fs = append(fs, formatter{Index: int(ix), Name: name, Format: f})
+	}
+	return fs, nil
+}
+
+// resolveFormatters gives to the formatters referencing a column by name the
+// index of this column in headers.
+func resolveFormatters(fs []formatter, headers []string) error {
+	for i, f := range fs {
+		if f.Name == "" {
+			continue
+		}
+		if fs[i].Index = indexOf(headers, f.Name); fs[i].Index < 0 {
+			return fmt.Errorf("%w: %s", ErrUnknown, f.Name)
+		}
+	}
+	return nil
+}
+
 func formatString(method string) func(string) (string, error) {
 	return func(v string) (string, error) {
 		switch method {
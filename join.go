@@ -0,0 +1,581 @@
+package comma
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Rows is the interface implemented by the types that give access to a
+// sequence of records such as Reader or Sorted.
+type Rows interface {
+	Next() ([]string, error)
+}
+
+type JoinMode int
+
+const (
+	InnerJoin JoinMode = iota
+	LeftJoin
+	RightJoin
+	FullJoin
+	SemiJoin
+	AntiJoin
+)
+
+func ParseJoinMode(str string) (JoinMode, error) {
+	switch strings.ToLower(str) {
+	case "", "inner":
+		return InnerJoin, nil
+	case "left":
+		return LeftJoin, nil
+	case "right":
+		return RightJoin, nil
+	case "full", "outer", "full-outer":
+		return FullJoin, nil
+	case "semi":
+		return SemiJoin, nil
+	case "anti":
+		return AntiJoin, nil
+	default:
+		return 0, fmt.Errorf("unknown join mode %s", str)
+	}
+}
+
+func (m JoinMode) String() string {
+	switch m {
+	case InnerJoin:
+		return "inner"
+	case LeftJoin:
+		return "left"
+	case RightJoin:
+		return "right"
+	case FullJoin:
+		return "full"
+	case SemiJoin:
+		return "semi"
+	case AntiJoin:
+		return "anti"
+	default:
+		return "unknown"
+	}
+}
+
+func (m JoinMode) keepLeft() bool {
+	return m == LeftJoin || m == FullJoin
+}
+
+func (m JoinMode) keepRight() bool {
+	return m == RightJoin || m == FullJoin
+}
+
+func (m JoinMode) leftOnly() bool {
+	return m == SemiJoin || m == AntiJoin
+}
+
+// Join combines the records of two Rows having the same values for their
+// keys. When one of the sides fits in the memory limit, Join builds a hash
+// table with its records and streams the other side. Otherwise, both sides
+// are sorted and merged.
+type Join struct {
+	left  Rows
+	right Rows
+	lkeys []Selection
+	rkeys []Selection
+	mode  JoinMode
+
+	limit   int64
+	options []SortOption
+
+	// lwidth and rwidth are the number of columns of the first row read on
+	// each side, lempty and rempty the number used when a side has no rows.
+	lwidth int
+	rwidth int
+	lempty int
+	rempty int
+
+	next    func() ([]string, error)
+	pending [][]string
+	closers []*Sorted
+}
+
+func NewJoin(left, right Rows, lkeys, rkeys []Selection, mode JoinMode, options ...SortOption) (*Join, error) {
+	if len(lkeys) == 0 || len(rkeys) == 0 {
+		return nil, fmt.Errorf("join: no keys given")
+	}
+	s, err := NewSorter(nil, options...)
+	if err != nil {
+		return nil, err
+	}
+	j := Join{
+		left:    left,
+		right:   right,
+		lkeys:   lkeys,
+		rkeys:   rkeys,
+		mode:    mode,
+		limit:   s.limit,
+		options: options,
+		lempty:  rowsWidth(left, lkeys),
+		rempty:  rowsWidth(right, rkeys),
+	}
+	return &j, nil
+}
+
+// rowsWidth gives the number of columns of rs before any row is read: the
+// number of its headers if rs has some (eg: Reader) or the number of columns
+// needed by its keys.
+func rowsWidth(rs Rows, keys []Selection) int {
+	if h, ok := rs.(interface{ Headers() []string }); ok {
+		if n := len(h.Headers()); n > 0 {
+			return n
+		}
+	}
+	var width int
+	for _, k := range keys {
+		if k.start > width {
+			width = k.start
+		}
+		if k.end > width {
+			width = k.end
+		}
+	}
+	return width
+}
+
+func (j *Join) Next() ([]string, error) {
+	if j.next == nil {
+		if err := j.prepare(); err != nil {
+			return nil, err
+		}
+	}
+	for len(j.pending) == 0 {
+		row, err := j.next()
+		if err != nil {
+			return nil, err
+		}
+		if row != nil {
+			return row, nil
+		}
+	}
+	row := j.pending[0]
+	j.pending = j.pending[1:]
+	return row, nil
+}
+
+// Close releases the temporary files created when the Join has to sort its
+// inputs.
+func (j *Join) Close() error {
+	var err error
+	for _, c := range j.closers {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (j *Join) prepare() error {
+	rs, rdone, err := j.load(j.right, &j.rwidth)
+	if err != nil {
+		return err
+	}
+	if rdone {
+		h, err := j.buildHash(rs, j.rkeys)
+		if err != nil {
+			return err
+		}
+		j.next = j.probeLeft(h)
+		return nil
+	}
+	ls, ldone, err := j.load(j.left, &j.lwidth)
+	if err != nil {
+		return err
+	}
+	right := &preloaded{rows: rs, Rows: j.right}
+	if ldone {
+		h, err := j.buildHash(ls, j.lkeys)
+		if err != nil {
+			return err
+		}
+		j.right = right
+		j.next = j.probeRight(h)
+		return nil
+	}
+	left := &preloaded{rows: ls, Rows: j.left}
+	return j.prepareMerge(left, right)
+}
+
+// load reads records from rs until all of them have been read or until the
+// memory limit is reached.
+func (j *Join) load(rs Rows, width *int) ([][]string, bool, error) {
+	var (
+		rows [][]string
+		size int64
+	)
+	for size < j.limit {
+		row, err := rs.Next()
+		if err == io.EOF {
+			return rows, true, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if len(rows) == 0 {
+			*width = len(row)
+		}
+		rows = append(rows, row)
+		size += rowSize(sortRow{row: row})
+	}
+	return rows, false, nil
+}
+
+type hashTable struct {
+	rows    [][]string
+	matched []bool
+	index   map[string][]int
+}
+
+func (j *Join) buildHash(rows [][]string, keys []Selection) (*hashTable, error) {
+	h := hashTable{
+		rows:    rows,
+		matched: make([]bool, len(rows)),
+		index:   make(map[string][]int),
+	}
+	for i, r := range rows {
+		k, err := joinKey(r, keys)
+		if err != nil {
+			return nil, err
+		}
+		h.index[k] = append(h.index[k], i)
+	}
+	return &h, nil
+}
+
+// probeLeft streams the left side against the records of the right side
+// kept in h. The rows returned are always in the order of the left side.
+func (j *Join) probeLeft(h *hashTable) func() ([]string, error) {
+	var done bool
+	return func() ([]string, error) {
+		if done {
+			return nil, io.EOF
+		}
+		row, err := j.left.Next()
+		if err == io.EOF {
+			done = true
+			if j.mode.keepRight() {
+				j.unmatched(h, false)
+			}
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if j.lwidth == 0 {
+			j.lwidth = len(row)
+		}
+		k, err := joinKey(row, j.lkeys)
+		if err != nil {
+			return nil, err
+		}
+		ix := h.index[k]
+		switch j.mode {
+		case SemiJoin:
+			if len(ix) > 0 {
+				return row, nil
+			}
+		case AntiJoin:
+			if len(ix) == 0 {
+				return row, nil
+			}
+		default:
+			for _, i := range ix {
+				h.matched[i] = true
+				j.pending = append(j.pending, j.combine(row, h.rows[i]))
+			}
+			if len(ix) == 0 && j.mode.keepLeft() {
+				return j.combine(row, nil), nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// probeRight streams the right side against the records of the left side
+// kept in h.
+func (j *Join) probeRight(h *hashTable) func() ([]string, error) {
+	var done bool
+	return func() ([]string, error) {
+		if done {
+			return nil, io.EOF
+		}
+		row, err := j.right.Next()
+		if err == io.EOF {
+			done = true
+			switch j.mode {
+			case SemiJoin:
+				j.matched(h)
+			case AntiJoin, LeftJoin, FullJoin:
+				j.unmatched(h, true)
+			}
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if j.rwidth == 0 {
+			j.rwidth = len(row)
+		}
+		k, err := joinKey(row, j.rkeys)
+		if err != nil {
+			return nil, err
+		}
+		ix := h.index[k]
+		for _, i := range ix {
+			h.matched[i] = true
+			if !j.mode.leftOnly() {
+				j.pending = append(j.pending, j.combine(h.rows[i], row))
+			}
+		}
+		if len(ix) == 0 && j.mode.keepRight() {
+			return j.combine(nil, row), nil
+		}
+		return nil, nil
+	}
+}
+
+func (j *Join) matched(h *hashTable) {
+	for i, ok := range h.matched {
+		if ok {
+			j.pending = append(j.pending, h.rows[i])
+		}
+	}
+}
+
+func (j *Join) unmatched(h *hashTable, left bool) {
+	for i, ok := range h.matched {
+		if ok {
+			continue
+		}
+		switch {
+		case left && j.mode.leftOnly():
+			j.pending = append(j.pending, h.rows[i])
+		case left:
+			j.pending = append(j.pending, j.combine(h.rows[i], nil))
+		default:
+			j.pending = append(j.pending, j.combine(nil, h.rows[i]))
+		}
+	}
+}
+
+func (j *Join) prepareMerge(left, right Rows) error {
+	ls, err := j.sortRows(left, j.lkeys)
+	if err != nil {
+		return err
+	}
+	rs, err := j.sortRows(right, j.rkeys)
+	if err != nil {
+		return err
+	}
+	lg := groupRows{rows: ls, keys: j.lkeys}
+	rg := groupRows{rows: rs, keys: j.rkeys}
+	if err := lg.advance(); err != nil {
+		return err
+	}
+	if err := rg.advance(); err != nil {
+		return err
+	}
+	j.next = func() ([]string, error) {
+		if lg.done && rg.done {
+			return nil, io.EOF
+		}
+		var cmp int
+		switch {
+		case lg.done:
+			cmp = 1
+		case rg.done:
+			cmp = -1
+		default:
+			cmp = compareKeys(lg.key, rg.key)
+		}
+		switch {
+		case cmp < 0:
+			for _, r := range lg.group {
+				if j.mode == AntiJoin {
+					j.pending = append(j.pending, r)
+				} else if j.mode.keepLeft() {
+					j.pending = append(j.pending, j.combine(r, nil))
+				}
+			}
+			return nil, lg.advance()
+		case cmp > 0:
+			for _, r := range rg.group {
+				if j.mode.keepRight() {
+					j.pending = append(j.pending, j.combine(nil, r))
+				}
+			}
+			return nil, rg.advance()
+		default:
+			for _, r := range lg.group {
+				switch j.mode {
+				case SemiJoin:
+					j.pending = append(j.pending, r)
+				case AntiJoin:
+				default:
+					for _, x := range rg.group {
+						j.pending = append(j.pending, j.combine(r, x))
+					}
+				}
+			}
+			if err := lg.advance(); err != nil {
+				return nil, err
+			}
+			return nil, rg.advance()
+		}
+	}
+	return nil
+}
+
+func (j *Join) sortRows(rs Rows, keys []Selection) (*Sorted, error) {
+	ks, err := ParseSortKeys(keys, nil)
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewSorter(ks, j.options...)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		row, err := rs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Push(row); err != nil {
+			return nil, err
+		}
+	}
+	sorted, err := s.Sort()
+	if err == nil {
+		j.closers = append(j.closers, sorted)
+	}
+	return sorted, err
+}
+
+func (j *Join) combine(left, right []string) []string {
+	if j.mode.leftOnly() {
+		return left
+	}
+	if left == nil {
+		left = make([]string, emptyWidth(j.lwidth, j.lempty))
+	}
+	if right == nil {
+		right = make([]string, emptyWidth(j.rwidth, j.rempty))
+	}
+	row := make([]string, 0, len(left)+len(right))
+	row = append(row, left...)
+	return append(row, right...)
+}
+
+func emptyWidth(width, empty int) int {
+	if width > 0 {
+		return width
+	}
+	return empty
+}
+
+// groupRows reads all the consecutive records of a sorted Rows having the
+// same key.
+type groupRows struct {
+	rows Rows
+	keys []Selection
+
+	key   []string
+	group [][]string
+	next  []string
+	done  bool
+}
+
+func (g *groupRows) advance() error {
+	g.group = g.group[:0]
+	if g.next == nil {
+		row, err := g.rows.Next()
+		if err == io.EOF {
+			g.done = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		g.next = row
+	}
+	key, err := selectValues(g.next, g.keys)
+	if err != nil {
+		return err
+	}
+	g.key, g.group, g.next = key, append(g.group, g.next), nil
+	for {
+		row, err := g.rows.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		k, err := selectValues(row, g.keys)
+		if err != nil {
+			return err
+		}
+		if compareKeys(key, k) != 0 {
+			g.next = row
+			return nil
+		}
+		g.group = append(g.group, row)
+	}
+}
+
+// preloaded gives the records already read from Rows before the ones that
+// remain to be read.
+type preloaded struct {
+	Rows
+	rows [][]string
+}
+
+func (p *preloaded) Next() ([]string, error) {
+	if len(p.rows) > 0 {
+		row := p.rows[0]
+		p.rows = p.rows[1:]
+		return row, nil
+	}
+	return p.Rows.Next()
+}
+
+func selectValues(row []string, sel []Selection) ([]string, error) {
+	ds := make([]string, 0, len(sel))
+	for _, s := range sel {
+		vs, err := s.Select(row)
+		if err != nil {
+			return nil, err
+		}
+		ds = append(ds, vs...)
+	}
+	return ds, nil
+}
+
+func joinKey(row []string, sel []Selection) (string, error) {
+	vs, err := selectValues(row, sel)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(vs, "\x00"), nil
+}
+
+func compareKeys(k1, k2 []string) int {
+	for i := 0; i < len(k1) && i < len(k2); i++ {
+		if c := strings.Compare(k1[i], k2[i]); c != 0 {
+			return c
+		}
+	}
+	return len(k1) - len(k2)
+}
@@ -0,0 +1,159 @@
+package comma
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type sliceRows [][]string
+
+func (s *sliceRows) Next() ([]string, error) {
+	if len(*s) == 0 {
+		return nil, io.EOF
+	}
+	row := (*s)[0]
+	*s = (*s)[1:]
+	return row, nil
+}
+
+func TestJoin(t *testing.T) {
+	left := [][]string{
+		{"1", "foo"},
+		{"2", "bar"},
+		{"3", "baz"},
+		{"2", "qux"},
+	}
+	right := [][]string{
+		{"a", "2"},
+		{"b", "4"},
+		{"c", "1"},
+		{"d", "2"},
+	}
+	data := []struct {
+		Mode JoinMode
+		Want []string
+	}{
+		{
+			Mode: InnerJoin,
+			Want: []string{"1,foo,c,1", "2,bar,a,2", "2,bar,d,2", "2,qux,a,2", "2,qux,d,2"},
+		},
+		{
+			Mode: LeftJoin,
+			Want: []string{"1,foo,c,1", "2,bar,a,2", "2,bar,d,2", "2,qux,a,2", "2,qux,d,2", "3,baz,,"},
+		},
+		{
+			Mode: RightJoin,
+			Want: []string{",,b,4", "1,foo,c,1", "2,bar,a,2", "2,bar,d,2", "2,qux,a,2", "2,qux,d,2"},
+		},
+		{
+			Mode: FullJoin,
+			Want: []string{",,b,4", "1,foo,c,1", "2,bar,a,2", "2,bar,d,2", "2,qux,a,2", "2,qux,d,2", "3,baz,,"},
+		},
+		{
+			Mode: SemiJoin,
+			Want: []string{"1,foo", "2,bar", "2,qux"},
+		},
+		{
+			Mode: AntiJoin,
+			Want: []string{"3,baz"},
+		},
+	}
+	lkeys, _ := ParseSelection("1")
+	rkeys, _ := ParseSelection("2")
+	for _, d := range data {
+		// memory limits to use the hash join (with the right then with the left
+		// side in memory) and the sort-merge join.
+		for _, limit := range []int64{DefaultMemoryLimit, 400, 1} {
+			ls, rs := sliceRows(left), sliceRows(right)
+			if limit == 400 {
+				rs = append(rs, right...)
+			}
+			j, err := NewJoin(&ls, &rs, lkeys, rkeys, d.Mode, WithMemoryLimit(limit))
+			if err != nil {
+				t.Errorf("%s) fail to create join: %s", d.Mode, err)
+				continue
+			}
+			var got []string
+			for {
+				row, err := j.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Errorf("%s) fail to join rows: %s", d.Mode, err)
+					break
+				}
+				got = append(got, strings.Join(row, ","))
+			}
+			j.Close()
+
+			want := d.Want
+			if limit == 400 && !d.Mode.leftOnly() {
+				want = duplicateMatches(want)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ";") != strings.Join(want, ";") {
+				t.Errorf("%s) wrong rows (limit: %d): want %v, got %v", d.Mode, limit, want, got)
+			}
+		}
+	}
+}
+
+func TestJoinEmptySide(t *testing.T) {
+	right := [][]string{
+		{"a", "2"},
+		{"b", "4"},
+	}
+	lkeys, _ := ParseSelection("1")
+	rkeys, _ := ParseSelection("2")
+	for _, mode := range []JoinMode{RightJoin, FullJoin} {
+		for _, limit := range []int64{DefaultMemoryLimit, 1} {
+			ls, rs := sliceRows(nil), sliceRows(right)
+			j, err := NewJoin(&ls, &rs, lkeys, rkeys, mode, WithMemoryLimit(limit))
+			if err != nil {
+				t.Fatalf("%s) fail to create join: %s", mode, err)
+			}
+			var got []string
+			for {
+				row, err := j.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("%s) fail to join rows: %s", mode, err)
+				}
+				got = append(got, strings.Join(row, ","))
+			}
+			j.Close()
+
+			sort.Strings(got)
+			if want := []string{",a,2", ",b,4"}; strings.Join(got, ";") != strings.Join(want, ";") {
+				t.Errorf("%s) wrong rows (limit: %d): want %v, got %v", mode, limit, want, got)
+			}
+		}
+	}
+	// the headers of a Reader give the width of its rows.
+	r, err := NewReader(strings.NewReader("id,name,value\n"), WithHeader())
+	if err != nil {
+		t.Fatalf("fail to create reader: %s", err)
+	}
+	if n := rowsWidth(r, lkeys); n != 3 {
+		t.Errorf("wrong width: want 3, got %d", n)
+	}
+}
+
+// duplicateMatches gives the expected rows when the right side contains
+// twice the same records.
+func duplicateMatches(rows []string) []string {
+	var vs []string
+	for _, r := range rows {
+		vs = append(vs, r)
+		if !strings.HasSuffix(r, ",,") {
+			vs = append(vs, r)
+		}
+	}
+	sort.Strings(vs)
+	return vs
+}
@@ -0,0 +1,270 @@
+package comma
+
+import (
+	"math"
+	"sort"
+
+	"github.com/midbel/comma/eval"
+)
+
+const (
+	// DefaultExactLimit is the number of values a quantile aggregate keeps
+	// before switching to a t-digest.
+	DefaultExactLimit = 1 << 12
+	// DefaultCompression is the compression of the t-digests used by the
+	// quantile aggregates. Higher values give more accurate results but use
+	// more memory.
+	DefaultCompression = 100
+)
+
+type quantile struct {
+	q     float64
+	limit int
+
+	values  [][]float64
+	digests []*tdigest
+}
+
+// Quantile gives the q-quantile (0 <= q <= 1) of the values of each column.
+// The values are kept in memory and the result is exact as long as a group
+// has less than DefaultExactLimit values. Past that limit, the values are
+// summarized by a t-digest and the result is an estimation.
+func Quantile(q float64) Aggr {
+	return &quantile{q: q, limit: DefaultExactLimit}
+}
+
+// ExactQuantile is like Quantile but keeps all the values in memory.
+func ExactQuantile(q float64) Aggr {
+	return &quantile{q: q, limit: -1}
+}
+
+func Median() Aggr {
+	return Quantile(0.5)
+}
+
+func (a *quantile) Aggr(vs []string) error {
+	if len(vs) == 0 {
+		return nil
+	}
+	n := len(a.values) + len(a.digests)
+	if n == 0 {
+		a.values = make([][]float64, len(vs))
+	} else if n != len(vs) {
+		return ErrRange
+	}
+	for i, v := range vs {
+		if isNull(v) {
+			continue
+		}
+		f, err := parseFloat(v)
+		if err != nil {
+			return err
+		}
+		if a.digests != nil {
+			a.digests[i].Add(f)
+			continue
+		}
+		a.values[i] = append(a.values[i], f)
+		if a.limit >= 0 && len(a.values[i]) > a.limit {
+			a.summarize()
+		}
+	}
+	return nil
+}
+
+func (a *quantile) Result() []eval.Value {
+	var vs []float64
+	if a.digests != nil {
+		vs = make([]float64, len(a.digests))
+		for i, d := range a.digests {
+			vs[i] = d.Quantile(a.q)
+		}
+	} else {
+		vs = make([]float64, len(a.values))
+		for i := range a.values {
+			vs[i] = exactQuantile(a.values[i], a.q)
+		}
+	}
+	return literals(vs)
+}
+
+func (a *quantile) Merge(other Aggr) error {
+	o, ok := other.(*quantile)
+	if !ok || o.q != a.q {
+		return ErrMerge
+	}
+	n := len(o.values) + len(o.digests)
+	switch m := len(a.values) + len(a.digests); {
+	case n == 0:
+		return nil
+	case m == 0:
+		a.values = make([][]float64, n)
+	case m != n:
+		return ErrRange
+	}
+	if a.digests == nil && o.digests == nil {
+		var over bool
+		for i := range o.values {
+			a.values[i] = append(a.values[i], o.values[i]...)
+			over = over || (a.limit >= 0 && len(a.values[i]) > a.limit)
+		}
+		if over {
+			a.summarize()
+		}
+		return nil
+	}
+	if a.digests == nil {
+		a.summarize()
+	}
+	for i := range a.digests {
+		if o.digests != nil {
+			a.digests[i].Merge(o.digests[i])
+			continue
+		}
+		for _, v := range o.values[i] {
+			a.digests[i].Add(v)
+		}
+	}
+	return nil
+}
+
+// summarize moves the values kept so far into t-digests.
+func (a *quantile) summarize() {
+	a.digests = make([]*tdigest, len(a.values))
+	for i, vs := range a.values {
+		a.digests[i] = newDigest(DefaultCompression)
+		for _, v := range vs {
+			a.digests[i].Add(v)
+		}
+	}
+	a.values = nil
+}
+
+func exactQuantile(vs []float64, q float64) float64 {
+	if len(vs) == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(vs)
+	pos := q * float64(len(vs)-1)
+	i := int(pos)
+	if i >= len(vs)-1 {
+		return vs[len(vs)-1]
+	}
+	return vs[i] + (pos-float64(i))*(vs[i+1]-vs[i])
+}
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// tdigest is a merging t-digest as described by T. Dunning. Values are
+// buffered and regularly merged into centroids whose size is bounded by
+// their position in the distribution: small at the tails and large around
+// the median.
+type tdigest struct {
+	compression float64
+	count       float64
+	min         float64
+	max         float64
+
+	centroids []centroid
+	buffer    []centroid
+}
+
+func newDigest(compression float64) *tdigest {
+	return &tdigest{
+		compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+func (t *tdigest) Add(f float64) {
+	t.buffer = append(t.buffer, centroid{mean: f, weight: 1})
+	t.count++
+	t.min = math.Min(t.min, f)
+	t.max = math.Max(t.max, f)
+	if len(t.buffer) >= int(5*t.compression) {
+		t.compress()
+	}
+}
+
+// Merge adds the centroids of other to the digest.
+func (t *tdigest) Merge(other *tdigest) {
+	if other.count == 0 {
+		return
+	}
+	t.buffer = append(t.buffer, other.centroids...)
+	t.buffer = append(t.buffer, other.buffer...)
+	t.count += other.count
+	t.min = math.Min(t.min, other.min)
+	t.max = math.Max(t.max, other.max)
+	t.compress()
+}
+
+func (t *tdigest) compress() {
+	if len(t.buffer) == 0 {
+		return
+	}
+	cs := append(t.centroids, t.buffer...)
+	sort.Slice(cs, func(i, j int) bool { return cs[i].mean < cs[j].mean })
+
+	var (
+		merged = make([]centroid, 0, len(t.centroids)+1)
+		curr   = cs[0]
+		sofar  float64
+	)
+	for _, c := range cs[1:] {
+		w := curr.weight + c.weight
+		q := (sofar + w/2) / t.count
+		if w <= 4*t.count*q*(1-q)/t.compression {
+			curr.mean += (c.mean - curr.mean) * c.weight / w
+			curr.weight = w
+			continue
+		}
+		sofar += curr.weight
+		merged = append(merged, curr)
+		curr = c
+	}
+	t.centroids = append(merged, curr)
+	t.buffer = t.buffer[:0]
+}
+
+func (t *tdigest) Quantile(q float64) float64 {
+	t.compress()
+	switch n := len(t.centroids); {
+	case n == 0:
+		return math.NaN()
+	case n == 1 || q <= 0:
+		if n == 1 {
+			return t.centroids[0].mean
+		}
+		return t.min
+	case q >= 1:
+		return t.max
+	}
+	var (
+		index = q * t.count
+		cumul float64
+	)
+	for i, c := range t.centroids {
+		center := cumul + c.weight/2
+		if index < center {
+			if i == 0 {
+				return interpolate(t.min, c.mean, index/center)
+			}
+			p := t.centroids[i-1]
+			prev := cumul - p.weight/2
+			return interpolate(p.mean, c.mean, (index-prev)/(center-prev))
+		}
+		cumul += c.weight
+	}
+	last := t.centroids[len(t.centroids)-1]
+	center := t.count - last.weight/2
+	return interpolate(last.mean, t.max, (index-center)/(t.count-center))
+}
+
+func interpolate(from, to, ratio float64) float64 {
+	return from + ratio*(to-from)
+}
@@ -0,0 +1,55 @@
+package comma
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestQuantileExact(t *testing.T) {
+	data := []struct {
+		Q    float64
+		Want float64
+	}{
+		{Q: 0, Want: 1},
+		{Q: 0.5, Want: 5.5},
+		{Q: 0.9, Want: 9.1},
+		{Q: 1, Want: 10},
+	}
+	for _, d := range data {
+		a := Quantile(d.Q)
+		for i := 10; i > 0; i-- {
+			if err := a.Aggr([]string{strconv.Itoa(i)}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+		got := a.Result()
+		if len(got) != 1 || math.Abs(toFloat(got[0])-d.Want) > 1e-9 {
+			t.Errorf("q%.2f: want %f, got %v", d.Q, d.Want, got)
+		}
+	}
+}
+
+func TestQuantileDigest(t *testing.T) {
+	const size = 100000
+
+	rand.Seed(42)
+	qs := []float64{0.01, 0.25, 0.5, 0.9, 0.99}
+	as := make([]Aggr, len(qs))
+	for i := range qs {
+		as[i] = Quantile(qs[i])
+	}
+	for i := 0; i < size; i++ {
+		v := strconv.FormatFloat(rand.Float64()*1000, 'f', -1, 64)
+		for _, a := range as {
+			a.Aggr([]string{v})
+		}
+	}
+	for i, a := range as {
+		got, want := toFloat(a.Result()[0]), qs[i]*1000
+		if math.Abs(got-want) > 10 {
+			t.Errorf("q%.2f: want %f (+/- 10), got %f", qs[i], want, got)
+		}
+	}
+}
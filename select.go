@@ -2,6 +2,7 @@ package comma
 
 import (
 	"bytes"
+	"fmt"
 	"strconv"
 	"unicode"
 	"unicode/utf8"
@@ -19,12 +20,47 @@ type Selection struct {
 	repeat   int
 	interval bool
 	open     bool
+
+	startLabel string
+	endLabel   string
 }
 
 func ParseSelection(v string) ([]Selection, error) {
 	return parseSelection(v)
 }
 
+// ResolveSelection gives to each selection referencing columns by name the
+// index of these columns in headers.
+func ResolveSelection(sel []Selection, headers []string) ([]Selection, error) {
+	cs := make([]Selection, 0, len(sel))
+	for _, s := range sel {
+		s, err := s.Resolve(headers)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, s)
+	}
+	return cs, nil
+}
+
+func (s Selection) Resolve(headers []string) (Selection, error) {
+	if s.startLabel != "" {
+		i := indexOf(headers, s.startLabel)
+		if i < 0 {
+			return s, fmt.Errorf("%w: %s", ErrUnknown, s.startLabel)
+		}
+		s.start = i + 1
+	}
+	if s.endLabel != "" {
+		i := indexOf(headers, s.endLabel)
+		if i < 0 {
+			return s, fmt.Errorf("%w: %s", ErrUnknown, s.endLabel)
+		}
+		s.end = i + 1
+	}
+	return s, nil
+}
+
 func (s Selection) IsOpen() bool {
 	return s.interval && (s.start == 0 || s.end == 0)
 }
@@ -32,13 +68,19 @@ func (s Selection) IsOpen() bool {
 func (s Selection) String() string {
 	tmp := make([]byte, 0, 64)
 	if s.interval {
-		if s.start > 0 {
+		if s.startLabel != "" {
+			tmp = append(tmp, s.startLabel...)
+		} else if s.start > 0 {
 			tmp = strconv.AppendInt(tmp, int64(s.start), 10)
 		}
 		tmp = append(tmp, ':')
-		if s.end > 0 {
+		if s.endLabel != "" {
+			tmp = append(tmp, s.endLabel...)
+		} else if s.end > 0 {
 			tmp = strconv.AppendInt(tmp, int64(s.end), 10)
 		}
+	} else if s.startLabel != "" {
+		tmp = append(tmp, s.startLabel...)
 	} else {
 		tmp = strconv.AppendInt(tmp, int64(s.start), 10)
 	}
@@ -46,6 +88,9 @@ func (s Selection) String() string {
 }
 
 func (s Selection) Select(values []string) ([]string, error) {
+	if !s.isResolved() {
+		return nil, ErrUnknown
+	}
 	if s.interval {
 		return s.selectOpen(values)
 	} else {
@@ -101,6 +146,16 @@ func (s Selection) selectSingle(values []string) ([]string, error) {
 	return vs, nil
 }
 
+func (s Selection) isResolved() bool {
+	if s.startLabel != "" && s.start == 0 {
+		return false
+	}
+	if s.endLabel != "" && s.end == 0 {
+		return false
+	}
+	return true
+}
+
 func parseSelection(v string) ([]Selection, error) {
 	if len(v) == 0 {
 		return nil, nil
@@ -110,6 +165,7 @@ func parseSelection(v string) ([]Selection, error) {
 		repeat   int
 		cs       []Selection
 		str      bytes.Buffer
+		label    string
 		interval bool
 	)
 	for {
@@ -131,11 +187,11 @@ func parseSelection(v string) ([]Selection, error) {
 				str.Reset()
 			}
 			if n := len(cs); n > 0 && cs[n-1].interval && interval {
-				cs[n-1].end = i
+				cs[n-1].end, cs[n-1].endLabel = i, label
 			} else {
-				cs = append(cs, Selection{start: i, repeat: repeat})
+				cs = append(cs, Selection{start: i, startLabel: label, repeat: repeat})
 			}
-			interval = false
+			interval, label, repeat = false, "", 0
 
 			if k == utf8.RuneError {
 				return cs, nil
@@ -150,6 +206,7 @@ func parseSelection(v string) ([]Selection, error) {
 				str.Reset()
 				s.start = int(i)
 			}
+			s.startLabel, label = label, ""
 			s.open, s.interval = true, true
 			cs = append(cs, s)
 			interval = true
@@ -158,6 +215,9 @@ func parseSelection(v string) ([]Selection, error) {
 				return nil, ErrSyntax
 			}
 		case unicode.IsDigit(k):
+			if label != "" {
+				return nil, ErrSyntax
+			}
 			str.WriteRune(k)
 			for {
 				k, nn = utf8.DecodeRuneInString(v[n:])
@@ -167,20 +227,53 @@ func parseSelection(v string) ([]Selection, error) {
 				n += nn
 				str.WriteRune(k)
 			}
-			if k, nn = utf8.DecodeRuneInString(v[n:]); k == plus {
-				repeat = 1
-				n += nn
-				for {
-					k, nn = utf8.DecodeRuneInString(v[n:])
-					if k != plus {
-						break
-					}
-					n += nn
-					repeat++
+			repeat, n = readRepeat(v, n)
+		case isLabel(k, false):
+			if str.Len() > 0 {
+				return nil, ErrSyntax
+			}
+			pos := n - nn
+			for {
+				k, nn = utf8.DecodeRuneInString(v[n:])
+				if !isLabel(k, true) {
+					break
 				}
+				n += nn
 			}
+			label = v[pos:n]
+			repeat, n = readRepeat(v, n)
 		default:
 			return nil, ErrSyntax
 		}
 	}
 }
+
+func readRepeat(v string, n int) (int, int) {
+	var repeat int
+	for {
+		k, nn := utf8.DecodeRuneInString(v[n:])
+		if k != plus {
+			break
+		}
+		n += nn
+		repeat++
+	}
+	return repeat, n
+}
+
+func isLabel(k rune, all bool) bool {
+	ok := unicode.IsLetter(k) || k == '_'
+	if all {
+		ok = ok || unicode.IsDigit(k) || k == '-' || k == '.'
+	}
+	return ok
+}
+
+func indexOf(headers []string, name string) int {
+	for i, h := range headers {
+		if h == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,65 @@
+package comma
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSelection(t *testing.T) {
+	headers := []string{"name", "age", "city", "country"}
+	row := []string{"foo", "41", "bar", "be"}
+	data := []struct {
+		Input string
+		Want  []string
+	}{
+		{Input: "1", Want: []string{"foo"}},
+		{Input: "1,3", Want: []string{"foo", "bar"}},
+		{Input: "2:3", Want: []string{"41", "bar"}},
+		{Input: "3:", Want: []string{"bar", "be"}},
+		{Input: ":2", Want: []string{"foo", "41"}},
+		{Input: "1++", Want: []string{"foo", "foo"}},
+		{Input: "name", Want: []string{"foo"}},
+		{Input: "city,name", Want: []string{"bar", "foo"}},
+		{Input: "name,age:city", Want: []string{"foo", "41", "bar"}},
+		{Input: "city:age", Want: []string{"bar", "41"}},
+		{Input: "age:", Want: []string{"41", "bar", "be"}},
+		{Input: "4, name+", Want: []string{"be", "foo"}},
+	}
+	for i, d := range data {
+		sel, err := ParseSelection(d.Input)
+		if err != nil {
+			t.Errorf("%d) fail to parse %s: %s", i+1, d.Input, err)
+			continue
+		}
+		sel, err = ResolveSelection(sel, headers)
+		if err != nil {
+			t.Errorf("%d) fail to resolve %s: %s", i+1, d.Input, err)
+			continue
+		}
+		var got []string
+		for _, s := range sel {
+			vs, err := s.Select(row)
+			if err != nil {
+				t.Errorf("%d) fail to select %s: %s", i+1, s, err)
+				break
+			}
+			got = append(got, vs...)
+		}
+		if strings.Join(got, ",") != strings.Join(d.Want, ",") {
+			t.Errorf("%d) wrong selection %s: want %v, got %v", i+1, d.Input, d.Want, got)
+		}
+	}
+}
+
+func TestResolveSelection(t *testing.T) {
+	for _, str := range []string{"foo", "name,foo", "age:foo"} {
+		sel, err := ParseSelection(str)
+		if err != nil {
+			t.Errorf("fail to parse %s: %s", str, err)
+			continue
+		}
+		if _, err := ResolveSelection(sel, []string{"name", "age"}); err == nil {
+			t.Errorf("%s: unknown column should not be resolved", str)
+		}
+	}
+}
@@ -0,0 +1,589 @@
+package comma
+
+import (
+	"container/heap"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/midbel/timefmt"
+)
+
+const (
+	DefaultMemoryLimit = 256 << 20
+	maxMergeRuns       = 64
+)
+
+type SortKey struct {
+	sel     Selection
+	parse   func(string) sortValue
+	compare func(sortValue, sortValue) int
+	reverse bool
+}
+
+// ParseSortKeys creates one key per selection. Each key gets its type and its
+// order from the specifier at the same position (type[:order[:pattern]]).
+// Keys without specifier are compared as string in ascending order.
+func ParseSortKeys(sel []Selection, specs []string) ([]SortKey, error) {
+	if len(specs) > len(sel) {
+		return nil, fmt.Errorf("too many specifiers given (%d > %d)", len(specs), len(sel))
+	}
+	ks := make([]SortKey, 0, len(sel))
+	for i, s := range sel {
+		var spec string
+		if i < len(specs) {
+			spec = specs[i]
+		}
+		k, err := parseSortKey(s, spec)
+		if err != nil {
+			return nil, err
+		}
+		ks = append(ks, k)
+	}
+	return ks, nil
+}
+
+func parseSortKey(sel Selection, spec string) (SortKey, error) {
+	fields := strings.SplitN(spec, ":", 3)
+	for len(fields) < 3 {
+		fields = append(fields, "")
+	}
+	k := SortKey{sel: sel}
+	switch kind := strings.ToLower(fields[0]); kind {
+	case "", "string", "text":
+		k.parse, k.compare = parseString, compareString
+	case "natural":
+		k.parse, k.compare = parseString, compareNatural
+	case "number", "int", "float", "double":
+		k.parse, k.compare = parseNumber, compareNumber
+	case "date", "datetime":
+		fs := []string{"%Y-%m-%d", "%Y/%m/%d", "%Y-%j", "%Y/%j"}
+		if kind == "datetime" {
+			fs = []string{"%Y-%m-%d %H:%M:%S", "%Y-%m-%dT%H:%M:%S"}
+		}
+		if fields[2] != "" {
+			fs = []string{fields[2]}
+		}
+		k.parse, k.compare = parseTime(fs), compareTime
+	default:
+		return k, fmt.Errorf("unknown key type %s", fields[0])
+	}
+	switch strings.ToLower(fields[1]) {
+	case "", "asc":
+	case "desc":
+		k.reverse = true
+	default:
+		return k, fmt.Errorf("unknown sort order %s", fields[1])
+	}
+	return k, nil
+}
+
+type sortValue struct {
+	str   string
+	num   float64
+	when  time.Time
+	valid bool
+}
+
+func parseString(v string) sortValue {
+	return sortValue{str: v, valid: true}
+}
+
+func parseNumber(v string) sortValue {
+	f, err := parseFloat(v)
+	return sortValue{str: v, num: f, valid: err == nil}
+}
+
+func parseTime(patterns []string) func(string) sortValue {
+	return func(v string) sortValue {
+		for _, p := range patterns {
+			w, err := timefmt.Parse(strings.TrimSpace(v), p)
+			if err == nil {
+				return sortValue{str: v, when: w, valid: true}
+			}
+		}
+		return sortValue{str: v}
+	}
+}
+
+// invalid values (eg: text in a number column) are always ordered before the
+// valid ones and are compared as string between them.
+func compareInvalid(a, b sortValue) (int, bool) {
+	switch {
+	case a.valid && b.valid:
+		return 0, false
+	case !a.valid && !b.valid:
+		return strings.Compare(a.str, b.str), true
+	case !a.valid:
+		return -1, true
+	default:
+		return 1, true
+	}
+}
+
+func compareString(a, b sortValue) int {
+	return strings.Compare(a.str, b.str)
+}
+
+func compareNumber(a, b sortValue) int {
+	if c, ok := compareInvalid(a, b); ok {
+		return c
+	}
+	switch {
+	case a.num < b.num:
+		return -1
+	case a.num > b.num:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareTime(a, b sortValue) int {
+	if c, ok := compareInvalid(a, b); ok {
+		return c
+	}
+	switch {
+	case a.when.Before(b.when):
+		return -1
+	case a.when.After(b.when):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareNatural compares strings where sequences of digits are compared by
+// their numeric value (eg: file2 < file10).
+func compareNatural(a, b sortValue) int {
+	x, y := a.str, b.str
+	for len(x) > 0 && len(y) > 0 {
+		rx, _ := utf8.DecodeRuneInString(x)
+		ry, _ := utf8.DecodeRuneInString(y)
+		if unicode.IsDigit(rx) && unicode.IsDigit(ry) {
+			var dx, dy string
+			dx, x = splitDigits(x)
+			dy, y = splitDigits(y)
+			if c := compareDigits(dx, dy); c != 0 {
+				return c
+			}
+			continue
+		}
+		if rx != ry {
+			if rx < ry {
+				return -1
+			}
+			return 1
+		}
+		x, y = x[utf8.RuneLen(rx):], y[utf8.RuneLen(ry):]
+	}
+	return len(x) - len(y)
+}
+
+func splitDigits(str string) (string, string) {
+	i := strings.IndexFunc(str, func(r rune) bool { return !unicode.IsDigit(r) })
+	if i < 0 {
+		return str, ""
+	}
+	return str[:i], str[i:]
+}
+
+func compareDigits(x, y string) int {
+	tx, ty := strings.TrimLeft(x, "0"), strings.TrimLeft(y, "0")
+	if len(tx) != len(ty) {
+		return len(tx) - len(ty)
+	}
+	if c := strings.Compare(tx, ty); c != 0 {
+		return c
+	}
+	return len(x) - len(y)
+}
+
+type SortOption func(*Sorter) error
+
+// WithMemoryLimit sets the (approximative) number of bytes that a Sorter can
+// keep in memory before writing its rows to temporary files.
+func WithMemoryLimit(n int64) SortOption {
+	return func(s *Sorter) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid memory limit %d", n)
+		}
+		s.limit = n
+		return nil
+	}
+}
+
+func WithTempDir(dir string) SortOption {
+	return func(s *Sorter) error {
+		s.tmpdir = dir
+		return nil
+	}
+}
+
+type sortRow struct {
+	row  []string
+	keys [][]sortValue
+}
+
+// Sorter sorts rows according to a set of keys. When the rows pushed exceed
+// its memory limit, the Sorter writes sorted runs in temporary files and
+// merges them when Sort is called.
+type Sorter struct {
+	keys   []SortKey
+	limit  int64
+	tmpdir string
+
+	rows []sortRow
+	size int64
+	runs []string
+}
+
+func NewSorter(keys []SortKey, options ...SortOption) (*Sorter, error) {
+	s := Sorter{
+		keys:   keys,
+		limit:  DefaultMemoryLimit,
+		tmpdir: os.TempDir(),
+	}
+	for _, opt := range options {
+		if err := opt(&s); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+func (s *Sorter) Push(row []string) error {
+	r, err := s.makeRow(row)
+	if err != nil {
+		return err
+	}
+	s.rows = append(s.rows, r)
+	s.size += rowSize(r)
+	if s.size >= s.limit {
+		return s.spill()
+	}
+	return nil
+}
+
+// Sort returns the rows pushed so far in order. The Sorter should not be used
+// anymore after Sort has been called.
+func (s *Sorter) Sort() (*Sorted, error) {
+	s.sortRows()
+	if len(s.runs) == 0 {
+		return &Sorted{rows: s.rows}, nil
+	}
+	if len(s.rows) > 0 {
+		if err := s.spill(); err != nil {
+			return nil, err
+		}
+	}
+	for len(s.runs) > maxMergeRuns {
+		var runs []string
+		for i := 0; i < len(s.runs); i += maxMergeRuns {
+			j := i + maxMergeRuns
+			if j > len(s.runs) {
+				j = len(s.runs)
+			}
+			file, err := s.mergeRuns(s.runs[i:j])
+			if err != nil {
+				return nil, err
+			}
+			runs = append(runs, file)
+		}
+		s.runs = runs
+	}
+	return s.merge(s.runs)
+}
+
+func (s *Sorter) makeRow(row []string) (sortRow, error) {
+	return makeSortRow(s.keys, row)
+}
+
+func makeSortRow(keys []SortKey, row []string) (sortRow, error) {
+	r := sortRow{
+		row:  row,
+		keys: make([][]sortValue, len(keys)),
+	}
+	for i, k := range keys {
+		vs, err := k.sel.Select(row)
+		if err != nil {
+			return r, err
+		}
+		r.keys[i] = make([]sortValue, len(vs))
+		for j, v := range vs {
+			r.keys[i][j] = k.parse(v)
+		}
+	}
+	return r, nil
+}
+
+func (s *Sorter) compare(a, b sortRow) int {
+	return compareSortRows(s.keys, a, b)
+}
+
+func compareSortRows(keys []SortKey, a, b sortRow) int {
+	for i, k := range keys {
+		c := compareValues(a.keys[i], b.keys[i], k.compare)
+		if k.reverse {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareValues(a, b []sortValue, cmp func(sortValue, sortValue) int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := cmp(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+func (s *Sorter) sortRows() {
+	sort.SliceStable(s.rows, func(i, j int) bool {
+		return s.compare(s.rows[i], s.rows[j]) < 0
+	})
+}
+
+func (s *Sorter) spill() error {
+	s.sortRows()
+
+	f, err := ioutil.TempFile(s.tmpdir, "comma-sort-*.csv")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	for _, r := range s.rows {
+		if err := w.Write(runRecord(r.row)); err != nil {
+			os.Remove(f.Name())
+			return err
+		}
+	}
+	if w.Flush(); w.Error() != nil {
+		os.Remove(f.Name())
+		return w.Error()
+	}
+	s.runs = append(s.runs, f.Name())
+	s.rows, s.size = nil, 0
+	return nil
+}
+
+func (s *Sorter) mergeRuns(runs []string) (string, error) {
+	m, err := s.merge(runs)
+	if err != nil {
+		return "", err
+	}
+	defer m.Close()
+
+	f, err := ioutil.TempFile(s.tmpdir, "comma-sort-*.csv")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	for {
+		row, err := m.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = w.Write(runRecord(row))
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return "", err
+		}
+	}
+	if w.Flush(); w.Error() != nil {
+		os.Remove(f.Name())
+		return "", w.Error()
+	}
+	return f.Name(), nil
+}
+
+func (s *Sorter) merge(runs []string) (*Sorted, error) {
+	m := mergeHeap{sorter: s}
+	for i, file := range runs {
+		f, err := os.Open(file)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		r := csv.NewReader(f)
+		r.FieldsPerRecord = -1
+		m.files = append(m.files, runFile{
+			File:   f,
+			reader: r,
+			order:  i,
+		})
+	}
+	for i := range m.files {
+		if err := m.advance(&m.files[i]); err != nil && err != io.EOF {
+			m.Close()
+			return nil, err
+		}
+	}
+	heap.Init(&m)
+	return &Sorted{merger: &m}, nil
+}
+
+// Sorted gives access to the rows of a Sorter in order.
+type Sorted struct {
+	rows   []sortRow
+	merger *mergeHeap
+}
+
+func (s *Sorted) Next() ([]string, error) {
+	if s.merger != nil {
+		return s.merger.Next()
+	}
+	if len(s.rows) == 0 {
+		return nil, io.EOF
+	}
+	row := s.rows[0].row
+	s.rows = s.rows[1:]
+	return row, nil
+}
+
+// Close releases the temporary files used by the Sorter.
+func (s *Sorted) Close() error {
+	if s.merger != nil {
+		return s.merger.Close()
+	}
+	return nil
+}
+
+type runFile struct {
+	*os.File
+	reader *csv.Reader
+	order  int
+	curr   sortRow
+}
+
+type mergeHeap struct {
+	sorter *Sorter
+	files  []runFile
+	heads  []*runFile
+}
+
+func (m *mergeHeap) Next() ([]string, error) {
+	if m.Len() == 0 {
+		return nil, io.EOF
+	}
+	f := m.heads[0]
+	row := f.curr.row
+	switch err := m.read(f); err {
+	case nil:
+		heap.Fix(m, 0)
+	case io.EOF:
+		heap.Pop(m)
+	default:
+		return nil, err
+	}
+	return row, nil
+}
+
+func (m *mergeHeap) Close() error {
+	var err error
+	for _, f := range m.files {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+		os.Remove(f.Name())
+	}
+	m.files, m.heads = nil, nil
+	return err
+}
+
+func (m *mergeHeap) advance(f *runFile) error {
+	err := m.read(f)
+	if err == nil {
+		m.heads = append(m.heads, f)
+	}
+	return err
+}
+
+func (m *mergeHeap) read(f *runFile) error {
+	rec, err := f.reader.Read()
+	if err != nil {
+		return err
+	}
+	row, err := runRow(rec)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.Name(), err)
+	}
+	f.curr, err = m.sorter.makeRow(row)
+	return err
+}
+
+// runRecord gives the record written in the run files for row: the row is
+// prefixed by its number of fields. Without it, a row made of one empty field
+// would be written as a blank line, skipped when the run is read.
+func runRecord(row []string) []string {
+	return append([]string{strconv.Itoa(len(row))}, row...)
+}
+
+// runRow gives the row of a record read from a run file.
+func runRow(rec []string) ([]string, error) {
+	if len(rec) == 0 {
+		return nil, ErrEmpty
+	}
+	n, err := strconv.Atoi(rec[0])
+	if err != nil || n != len(rec)-1 {
+		return nil, fmt.Errorf("run: %w", ErrSyntax)
+	}
+	return rec[1:], nil
+}
+
+func (m *mergeHeap) Len() int {
+	return len(m.heads)
+}
+
+func (m *mergeHeap) Less(i, j int) bool {
+	c := m.sorter.compare(m.heads[i].curr, m.heads[j].curr)
+	if c == 0 {
+		return m.heads[i].order < m.heads[j].order
+	}
+	return c < 0
+}
+
+func (m *mergeHeap) Swap(i, j int) {
+	m.heads[i], m.heads[j] = m.heads[j], m.heads[i]
+}
+
+func (m *mergeHeap) Push(x interface{}) {
+	m.heads = append(m.heads, x.(*runFile))
+}
+
+func (m *mergeHeap) Pop() interface{} {
+	n := len(m.heads)
+	f := m.heads[n-1]
+	m.heads = m.heads[:n-1]
+	return f
+}
+
+func rowSize(r sortRow) int64 {
+	size := 48 + 16*len(r.row) + 24*len(r.keys)
+	for _, v := range r.row {
+		size += len(v)
+	}
+	for _, k := range r.keys {
+		size += 64 * len(k)
+	}
+	return int64(size)
+}
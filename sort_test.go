@@ -0,0 +1,131 @@
+package comma
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSorter(t *testing.T) {
+	rows := [][]string{
+		{"b", "10", "2020-03-01", "file10"},
+		{"a", "9", "2020-01-15", "file2"},
+		{"c", "10", "2019-12-31", "file1"},
+		{"a", "100", "2020-02-01", "file20"},
+	}
+	data := []struct {
+		Keys  string
+		Specs []string
+		Want  []string
+	}{
+		{Keys: "1", Want: []string{"a", "a", "b", "c"}},
+		{Keys: "2", Specs: []string{"number"}, Want: []string{"9", "10", "10", "100"}},
+		{Keys: "2,1", Specs: []string{"number:desc", "string:desc"}, Want: []string{"100", "10", "10", "9"}},
+		{Keys: "3", Specs: []string{"date:desc"}, Want: []string{"2020-03-01", "2020-02-01", "2020-01-15", "2019-12-31"}},
+		{Keys: "4", Specs: []string{"natural"}, Want: []string{"file1", "file2", "file10", "file20"}},
+	}
+	for i, d := range data {
+		sel, err := ParseSelection(d.Keys)
+		if err != nil {
+			t.Errorf("%d) fail to parse selection %s: %s", i+1, d.Keys, err)
+			continue
+		}
+		keys, err := ParseSortKeys(sel, d.Specs)
+		if err != nil {
+			t.Errorf("%d) fail to parse keys %s: %s", i+1, d.Specs, err)
+			continue
+		}
+		for _, limit := range []int64{DefaultMemoryLimit, 1} {
+			got, err := sortRows(keys, rows, limit)
+			if err != nil {
+				t.Errorf("%d) fail to sort rows: %s", i+1, err)
+				continue
+			}
+			var col int
+			if sel[0].start > 0 {
+				col = sel[0].start - 1
+			}
+			var vs []string
+			for _, r := range got {
+				vs = append(vs, r[col])
+			}
+			if strings.Join(vs, ",") != strings.Join(d.Want, ",") {
+				t.Errorf("%d) rows not sorted (limit: %d): want %v, got %v", i+1, limit, d.Want, vs)
+			}
+		}
+	}
+}
+
+func TestSorterMerge(t *testing.T) {
+	sel, _ := ParseSelection("1")
+	keys, err := ParseSortKeys(sel, []string{"number"})
+	if err != nil {
+		t.Fatalf("fail to parse keys: %s", err)
+	}
+	var rows [][]string
+	for i := 0; i < 500; i++ {
+		rows = append(rows, []string{fmt.Sprint((i * 37) % 500)})
+	}
+	got, err := sortRows(keys, rows, 1)
+	if err != nil {
+		t.Fatalf("fail to sort rows: %s", err)
+	}
+	if len(got) != len(rows) {
+		t.Fatalf("wrong number of rows: want %d, got %d", len(rows), len(got))
+	}
+	for i, r := range got {
+		if r[0] != fmt.Sprint(i) {
+			t.Fatalf("rows not sorted at %d: got %s", i, r[0])
+		}
+	}
+}
+
+func TestSorterEmptyRows(t *testing.T) {
+	sel, _ := ParseSelection("1")
+	keys, err := ParseSortKeys(sel, nil)
+	if err != nil {
+		t.Fatalf("fail to parse keys: %s", err)
+	}
+	rows := [][]string{{"b"}, {""}, {"a"}}
+	for _, limit := range []int64{DefaultMemoryLimit, 1} {
+		got, err := sortRows(keys, rows, limit)
+		if err != nil {
+			t.Fatalf("fail to sort rows: %s", err)
+		}
+		want := [][]string{{""}, {"a"}, {"b"}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("rows not sorted (limit: %d): want %q, got %q", limit, want, got)
+		}
+	}
+}
+
+func sortRows(keys []SortKey, rows [][]string, limit int64) ([][]string, error) {
+	s, err := NewSorter(keys, WithMemoryLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		if err := s.Push(r); err != nil {
+			return nil, err
+		}
+	}
+	rs, err := s.Sort()
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+
+	var got [][]string
+	for {
+		row, err := rs.Next()
+		if err == io.EOF {
+			return got, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		got = append(got, row)
+	}
+}
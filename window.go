@@ -0,0 +1,364 @@
+package comma
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/midbel/comma/eval"
+)
+
+// WindowFunc computes one value for each row of a partition. The rows of the
+// partition are given in order. Numeric functions skip the null values: the
+// rows with a null value get a null result.
+type WindowFunc struct {
+	sel    Selection
+	column bool
+	apply  func(vs []string, peers []int) ([]eval.Value, error)
+}
+
+// CumSum gives the running sum of the values of the column.
+func CumSum(sel Selection) WindowFunc {
+	return numericFunc(sel, func(fs []float64) []eval.Value {
+		var (
+			vs  = make([]eval.Value, len(fs))
+			sum float64
+		)
+		for i, f := range fs {
+			if math.IsNaN(f) {
+				vs[i] = eval.Null{}
+				continue
+			}
+			sum += f
+			vs[i] = eval.Literal(sum)
+		}
+		return vs
+	})
+}
+
+// RollingMean gives the mean of the values of the column over the current row
+// and the n-1 rows before it.
+func RollingMean(sel Selection, n int) (WindowFunc, error) {
+	return rollingFunc(sel, n, func(fs []float64) float64 {
+		var sum float64
+		for _, f := range fs {
+			sum += f
+		}
+		return sum / float64(len(fs))
+	})
+}
+
+// RollingMin is like RollingMean but gives the minimum of the values.
+func RollingMin(sel Selection, n int) (WindowFunc, error) {
+	return rollingFunc(sel, n, func(fs []float64) float64 {
+		m := fs[0]
+		for _, f := range fs[1:] {
+			m = math.Min(m, f)
+		}
+		return m
+	})
+}
+
+// RollingMax is like RollingMean but gives the maximum of the values.
+func RollingMax(sel Selection, n int) (WindowFunc, error) {
+	return rollingFunc(sel, n, func(fs []float64) float64 {
+		m := fs[0]
+		for _, f := range fs[1:] {
+			m = math.Max(m, f)
+		}
+		return m
+	})
+}
+
+// Lag gives the value of the column n rows before the current row - an empty
+// value for the first n rows of the partition.
+func Lag(sel Selection, n int) (WindowFunc, error) {
+	return offsetFunc(sel, -n)
+}
+
+// Lead gives the value of the column n rows after the current row - an empty
+// value for the last n rows of the partition.
+func Lead(sel Selection, n int) (WindowFunc, error) {
+	return offsetFunc(sel, n)
+}
+
+// Diff gives the difference between the value of the column and its value n
+// rows before the current row.
+func Diff(sel Selection, n int) (WindowFunc, error) {
+	if n <= 0 {
+		return WindowFunc{}, fmt.Errorf("offset %d: %w", n, ErrRange)
+	}
+	f := numericFunc(sel, func(fs []float64) []eval.Value {
+		vs := make([]eval.Value, len(fs))
+		for i := range fs {
+			if i < n || math.IsNaN(fs[i]) || math.IsNaN(fs[i-n]) {
+				vs[i] = eval.Null{}
+			} else {
+				vs[i] = eval.Literal(fs[i] - fs[i-n])
+			}
+		}
+		return vs
+	})
+	return f, nil
+}
+
+// RowNumber gives the position (from 1) of the rows in their partition.
+func RowNumber() WindowFunc {
+	return rankFunc(func(i int, peers []int) float64 {
+		return float64(i + 1)
+	})
+}
+
+// Rank gives the rank of the rows in their partition. Rows with the same
+// values for the keys of the Window have the same rank, leaving gaps after
+// them.
+func Rank() WindowFunc {
+	return rankFunc(func(i int, peers []int) float64 {
+		return float64(peers[i] + 1)
+	})
+}
+
+// DenseRank is like Rank but without gaps.
+func DenseRank() WindowFunc {
+	return WindowFunc{
+		apply: func(_ []string, peers []int) ([]eval.Value, error) {
+			var (
+				vs   = make([]eval.Value, len(peers))
+				rank float64
+			)
+			for i := range peers {
+				if peers[i] == i {
+					rank++
+				}
+				vs[i] = eval.Literal(rank)
+			}
+			return vs, nil
+		},
+	}
+}
+
+// PercentRank gives the relative rank of the rows in their partition:
+// (rank - 1) / (rows - 1).
+func PercentRank() WindowFunc {
+	return rankFunc(func(i int, peers []int) float64 {
+		if len(peers) <= 1 {
+			return 0
+		}
+		return float64(peers[i]) / float64(len(peers)-1)
+	})
+}
+
+func rankFunc(rank func(int, []int) float64) WindowFunc {
+	return WindowFunc{
+		apply: func(_ []string, peers []int) ([]eval.Value, error) {
+			vs := make([]eval.Value, len(peers))
+			for i := range peers {
+				vs[i] = eval.Literal(rank(i, peers))
+			}
+			return vs, nil
+		},
+	}
+}
+
+func offsetFunc(sel Selection, n int) (WindowFunc, error) {
+	if n == 0 {
+		return WindowFunc{}, fmt.Errorf("offset %d: %w", n, ErrRange)
+	}
+	f := WindowFunc{
+		sel:    sel,
+		column: true,
+		apply: func(vs []string, _ []int) ([]eval.Value, error) {
+			xs := make([]eval.Value, len(vs))
+			for i := range vs {
+				j := i + n
+				if j < 0 || j >= len(vs) {
+					xs[i] = eval.Text("")
+				} else {
+					xs[i] = eval.Text(vs[j])
+				}
+			}
+			return xs, nil
+		},
+	}
+	return f, nil
+}
+
+func rollingFunc(sel Selection, n int, fn func([]float64) float64) (WindowFunc, error) {
+	if n <= 0 {
+		return WindowFunc{}, fmt.Errorf("rows %d: %w", n, ErrRange)
+	}
+	f := numericFunc(sel, func(fs []float64) []eval.Value {
+		vs := make([]eval.Value, len(fs))
+		for i := range fs {
+			if math.IsNaN(fs[i]) {
+				vs[i] = eval.Null{}
+				continue
+			}
+			j := i - n + 1
+			if j < 0 {
+				j = 0
+			}
+			xs := make([]float64, 0, i+1-j)
+			for _, f := range fs[j : i+1] {
+				if !math.IsNaN(f) {
+					xs = append(xs, f)
+				}
+			}
+			vs[i] = eval.Literal(fn(xs))
+		}
+		return vs
+	})
+	return f, nil
+}
+
+func numericFunc(sel Selection, fn func([]float64) []eval.Value) WindowFunc {
+	return WindowFunc{
+		sel:    sel,
+		column: true,
+		apply: func(vs []string, _ []int) ([]eval.Value, error) {
+			fs := make([]float64, len(vs))
+			for i, v := range vs {
+				if isNull(v) {
+					fs[i] = math.NaN()
+					continue
+				}
+				f, err := parseFloat(v)
+				if err != nil {
+					return nil, cellError{pos: i, err: err}
+				}
+				fs[i] = f
+			}
+			return fn(fs), nil
+		},
+	}
+}
+
+// cellError reports the position, in its partition, of the row whose value
+// made a function fail.
+type cellError struct {
+	pos int
+	err error
+}
+
+func (e cellError) Error() string {
+	return e.err.Error()
+}
+
+func (e cellError) Unwrap() error {
+	return e.err
+}
+
+type windowRow struct {
+	sortRow
+	record int
+}
+
+// Window computes functions over partitions of rows. Rows are partitioned by
+// the values of a selection and ordered inside their partition by a set of
+// sort keys. The partitions are kept in memory.
+type Window struct {
+	partition []Selection
+	keys      []SortKey
+	funcs     []WindowFunc
+	nulls     Nulls
+
+	index map[string]int
+	parts [][]windowRow
+	count int
+}
+
+func NewWindow(partition []Selection, keys []SortKey, funcs ...WindowFunc) *Window {
+	return &Window{
+		partition: partition,
+		keys:      keys,
+		funcs:     funcs,
+		index:     make(map[string]int),
+	}
+}
+
+// SetNulls gives the values read as null values by the functions besides
+// the empty value.
+func (w *Window) SetNulls(nulls Nulls) {
+	w.nulls = nulls
+}
+
+func (w *Window) Push(row []string) error {
+	var ks []string
+	for _, s := range w.partition {
+		vs, err := s.Select(row)
+		if err != nil {
+			return err
+		}
+		ks = append(ks, vs...)
+	}
+	r, err := makeSortRow(w.keys, row)
+	if err != nil {
+		return err
+	}
+	key := strings.Join(ks, "\x00")
+	i, ok := w.index[key]
+	if !ok {
+		i = len(w.parts)
+		w.index[key] = i
+		w.parts = append(w.parts, nil)
+	}
+	w.count++
+	w.parts[i] = append(w.parts[i], windowRow{sortRow: r, record: w.count})
+	return nil
+}
+
+// Each calls fn with each row and the values computed by the functions for
+// this row. Partitions are given in the order of their first row and rows in
+// the order of the keys of the Window.
+func (w *Window) Each(fn func([]string, []eval.Value) error) error {
+	for _, rs := range w.parts {
+		sort.SliceStable(rs, func(i, j int) bool {
+			return compareSortRows(w.keys, rs[i].sortRow, rs[j].sortRow) < 0
+		})
+		peers := make([]int, len(rs))
+		for i := 1; i < len(rs); i++ {
+			if compareSortRows(w.keys, rs[i-1].sortRow, rs[i].sortRow) == 0 {
+				peers[i] = peers[i-1]
+			} else {
+				peers[i] = i
+			}
+		}
+		results := make([][]eval.Value, len(w.funcs))
+		for i, f := range w.funcs {
+			var vs []string
+			if f.column {
+				vs = make([]string, len(rs))
+				for j, r := range rs {
+					xs, err := f.sel.Select(r.row)
+					if err != nil {
+						return err
+					}
+					if len(xs) > 0 && !w.nulls.IsNull(xs[0]) {
+						vs[j] = xs[0]
+					}
+				}
+			}
+			res, err := f.apply(vs, peers)
+			if err != nil {
+				var e cellError
+				if errors.As(err, &e) {
+					err = fmt.Errorf("column %s, row %d: %w", f.sel, rs[e.pos].record, e.err)
+				}
+				return err
+			}
+			results[i] = res
+		}
+		for i, r := range rs {
+			vs := make([]eval.Value, len(results))
+			for j := range results {
+				vs[j] = results[j][i]
+			}
+			if err := fn(r.row, vs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
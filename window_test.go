@@ -0,0 +1,164 @@
+package comma
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/midbel/comma/eval"
+)
+
+func TestWindow(t *testing.T) {
+	rows := [][]string{
+		{"a", "2020-01-03", "30"},
+		{"b", "2020-01-01", "5"},
+		{"a", "2020-01-01", "10"},
+		{"a", "2020-01-02", "20"},
+		{"b", "2020-01-02", "5"},
+		{"a", "2020-01-02", "40"},
+	}
+	col := mustSelection(t, "3")[0]
+	data := []struct {
+		Name string
+		Func func() (WindowFunc, error)
+		Want []string
+	}{
+		{
+			Name: "cumsum",
+			Func: func() (WindowFunc, error) { return CumSum(col), nil },
+			Want: []string{"10", "30", "70", "100", "5", "10"},
+		},
+		{
+			Name: "rolling-mean",
+			Func: func() (WindowFunc, error) { return RollingMean(col, 2) },
+			Want: []string{"10", "15", "30", "35", "5", "5"},
+		},
+		{
+			Name: "rolling-max",
+			Func: func() (WindowFunc, error) { return RollingMax(col, 3) },
+			Want: []string{"10", "20", "40", "40", "5", "5"},
+		},
+		{
+			Name: "lag",
+			Func: func() (WindowFunc, error) { return Lag(col, 1) },
+			Want: []string{"", "10", "20", "40", "", "5"},
+		},
+		{
+			Name: "lead",
+			Func: func() (WindowFunc, error) { return Lead(col, 2) },
+			Want: []string{"40", "30", "", "", "", ""},
+		},
+		{
+			Name: "diff",
+			Func: func() (WindowFunc, error) { return Diff(col, 1) },
+			Want: []string{"", "10", "20", "-10", "", "0"},
+		},
+		{
+			Name: "row-number",
+			Func: func() (WindowFunc, error) { return RowNumber(), nil },
+			Want: []string{"1", "2", "3", "4", "1", "2"},
+		},
+		{
+			Name: "rank",
+			Func: func() (WindowFunc, error) { return Rank(), nil },
+			Want: []string{"1", "2", "2", "4", "1", "2"},
+		},
+		{
+			Name: "dense-rank",
+			Func: func() (WindowFunc, error) { return DenseRank(), nil },
+			Want: []string{"1", "2", "2", "3", "1", "2"},
+		},
+		{
+			Name: "percent-rank",
+			Func: func() (WindowFunc, error) { return PercentRank(), nil },
+			Want: []string{"0", "0.3333333333333333", "0.3333333333333333", "1", "0", "1"},
+		},
+	}
+	keys, err := ParseSortKeys(mustSelection(t, "2"), []string{"date"})
+	if err != nil {
+		t.Fatalf("fail to parse keys: %s", err)
+	}
+	for _, d := range data {
+		f, err := d.Func()
+		if err != nil {
+			t.Errorf("%s: fail to create function: %s", d.Name, err)
+			continue
+		}
+		w := NewWindow(mustSelection(t, "1"), keys, f)
+		for _, r := range rows {
+			if err := w.Push(r); err != nil {
+				t.Fatalf("%s: fail to push row: %s", d.Name, err)
+			}
+		}
+		var got []string
+		err = w.Each(func(_ []string, vs []eval.Value) error {
+			got = append(got, vs[0].String())
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if strings.Join(got, ",") != strings.Join(d.Want, ",") {
+			t.Errorf("%s: results mismatched: want %v, got %v", d.Name, d.Want, got)
+		}
+	}
+}
+
+func TestWindowInvalid(t *testing.T) {
+	col := mustSelection(t, "1")[0]
+	if _, err := RollingMean(col, 0); err == nil {
+		t.Errorf("rolling mean over 0 rows should fail")
+	}
+	if _, err := Lag(col, 0); err == nil {
+		t.Errorf("lag of 0 rows should fail")
+	}
+	w := NewWindow(nil, nil, CumSum(col))
+	w.Push([]string{"1"})
+	w.Push([]string{"foo"})
+	err := w.Each(func(_ []string, _ []eval.Value) error { return nil })
+	if err == nil {
+		t.Errorf("cumsum of non numeric values should fail")
+	} else if !strings.Contains(err.Error(), "column 1, row 2") {
+		t.Errorf("error should give the column and the row: %s", err)
+	}
+}
+
+func TestWindowNulls(t *testing.T) {
+	col := mustSelection(t, "1")[0]
+	mean, _ := RollingMean(col, 2)
+	diff, _ := Diff(col, 1)
+	w := NewWindow(nil, nil, CumSum(col), mean, diff)
+	w.SetNulls(Nulls{"NA": {}})
+	for _, v := range []string{"1", "", "3", "NA", "5"} {
+		w.Push([]string{v})
+	}
+	var got []string
+	err := w.Each(func(_ []string, vs []eval.Value) error {
+		for _, v := range vs {
+			got = append(got, v.String())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []string{
+		"1", "1", "",
+		"", "", "",
+		"4", "3", "",
+		"", "", "",
+		"9", "5", "",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("results mismatched: want %v, got %v", want, got)
+	}
+}
+
+func mustSelection(t *testing.T, str string) []Selection {
+	t.Helper()
+	sel, err := ParseSelection(str)
+	if err != nil {
+		t.Fatalf("fail to parse selection %s: %s", str, err)
+	}
+	return sel
+}
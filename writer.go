@@ -0,0 +1,250 @@
+package comma
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Quoting int
+
+const (
+	QuoteMinimal Quoting = iota
+	QuoteAll
+	QuoteNonNumeric
+	QuoteNone
+)
+
+func ParseQuoting(str string) (Quoting, error) {
+	switch strings.ToLower(str) {
+	case "", "minimal":
+		return QuoteMinimal, nil
+	case "all":
+		return QuoteAll, nil
+	case "non-numeric", "nonnumeric":
+		return QuoteNonNumeric, nil
+	case "none":
+		return QuoteNone, nil
+	default:
+		return 0, fmt.Errorf("unknown quoting policy %s", str)
+	}
+}
+
+const bom = "\ufeff"
+
+type WriterOption func(*Writer) error
+
+func WithOutputSeparator(c rune) WriterOption {
+	return func(w *Writer) error {
+		if !isSeparator(c) {
+			return fmt.Errorf("invalid separator %c", c)
+		}
+		w.comma = c
+		return nil
+	}
+}
+
+func WithOutputFormatters(specifiers []string) WriterOption {
+	return func(w *Writer) error {
+		fs, err := parseFormatters(specifiers)
+		if err == nil {
+			w.formatters = append(w.formatters, fs...)
+		}
+		return err
+	}
+}
+
+func WithQuoting(q Quoting) WriterOption {
+	return func(w *Writer) error {
+		w.quoting = q
+		return nil
+	}
+}
+
+// WithCRLF makes the Writer terminate each record with \r\n instead of \n.
+func WithCRLF() WriterOption {
+	return func(w *Writer) error {
+		w.crlf = true
+		return nil
+	}
+}
+
+// WithBOM makes the Writer start its output with an UTF-8 byte order mark.
+func WithBOM() WriterOption {
+	return func(w *Writer) error {
+		w.bom = true
+		return nil
+	}
+}
+
+// WithHeaders gives the names of the columns that the Writer emits before
+// its first record. Formatters can then reference columns by their names.
+func WithHeaders(headers []string) WriterOption {
+	return func(w *Writer) error {
+		w.headers = headers
+		return nil
+	}
+}
+
+// Writer writes records in CSV format. It is the counterpart of Reader.
+type Writer struct {
+	io.Closer
+	inner *bufio.Writer
+
+	comma   rune
+	quoting Quoting
+	crlf    bool
+	bom     bool
+	headers []string
+
+	formatters []formatter
+
+	started bool
+}
+
+func Create(file string, options ...WriterOption) (*Writer, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f, options...)
+	if err != nil {
+		f.Close()
+	}
+	return w, err
+}
+
+func NewWriter(w io.Writer, options ...WriterOption) (*Writer, error) {
+	ws := Writer{
+		inner: bufio.NewWriter(w),
+		comma: ',',
+	}
+	if x, ok := w.(io.Closer); ok {
+		ws.Closer = x
+	}
+	for _, opt := range options {
+		if err := opt(&ws); err != nil {
+			return nil, err
+		}
+	}
+	if err := resolveFormatters(ws.formatters, ws.headers); err != nil {
+		return nil, err
+	}
+	return &ws, nil
+}
+
+func (w *Writer) Write(row []string) error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	if len(w.formatters) > 0 {
+		row = append([]string{}, row...)
+		for _, f := range w.formatters {
+			if f.Index >= len(row) {
+				return ErrRange
+			}
+			v, err := f.Format(row[f.Index])
+			if err != nil {
+				return err
+			}
+			row[f.Index] = v
+		}
+	}
+	return w.write(row)
+}
+
+func (w *Writer) WriteAll(rows [][]string) error {
+	for _, r := range rows {
+		if err := w.Write(r); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func (w *Writer) Flush() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	return w.inner.Flush()
+}
+
+// Close flushes the Writer and closes its underlying io.Writer if it is an
+// io.Closer.
+func (w *Writer) Close() error {
+	err := w.Flush()
+	if w.Closer == nil {
+		return err
+	}
+	if e := w.Closer.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+func (w *Writer) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	if w.bom {
+		if _, err := w.inner.WriteString(bom); err != nil {
+			return err
+		}
+	}
+	if len(w.headers) > 0 {
+		return w.write(w.headers)
+	}
+	return nil
+}
+
+func (w *Writer) write(row []string) error {
+	for i, v := range row {
+		if i > 0 {
+			w.inner.WriteRune(w.comma)
+		}
+		// a record made of one empty field is quoted: it would be written as
+		// a blank line otherwise, skipped when the record is read back.
+		empty := len(row) == 1 && v == "" && w.quoting != QuoteNone
+		if !empty && !w.needQuotes(v) {
+			w.inner.WriteString(v)
+			continue
+		}
+		w.inner.WriteByte('"')
+		w.inner.WriteString(strings.ReplaceAll(v, "\"", "\"\""))
+		w.inner.WriteByte('"')
+	}
+	var err error
+	if w.crlf {
+		_, err = w.inner.WriteString("\r\n")
+	} else {
+		err = w.inner.WriteByte('\n')
+	}
+	return err
+}
+
+func (w *Writer) needQuotes(v string) bool {
+	switch w.quoting {
+	case QuoteAll:
+		return true
+	case QuoteNone:
+		return false
+	case QuoteNonNumeric:
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return true
+		}
+	}
+	if v == "" {
+		return false
+	}
+	if strings.ContainsRune(v, w.comma) || strings.ContainsAny(v, "\"\r\n") {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(v)
+	return unicode.IsSpace(r)
+}
@@ -0,0 +1,109 @@
+package comma
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	row := []string{"foo", "1.5", "hello, world", "say \"hi\"", ""}
+	data := []struct {
+		Options []WriterOption
+		Want    string
+	}{
+		{
+			Want: "foo,1.5,\"hello, world\",\"say \"\"hi\"\"\",\n",
+		},
+		{
+			Options: []WriterOption{WithQuoting(QuoteAll)},
+			Want:    "\"foo\",\"1.5\",\"hello, world\",\"say \"\"hi\"\"\",\"\"\n",
+		},
+		{
+			Options: []WriterOption{WithQuoting(QuoteNonNumeric)},
+			Want:    "\"foo\",1.5,\"hello, world\",\"say \"\"hi\"\"\",\"\"\n",
+		},
+		{
+			Options: []WriterOption{WithQuoting(QuoteNone), WithOutputSeparator(';')},
+			Want:    "foo;1.5;hello, world;say \"hi\";\n",
+		},
+		{
+			Options: []WriterOption{WithCRLF(), WithBOM(), WithHeaders([]string{"a", "b", "c", "d", "e"})},
+			Want:    "\ufeffa,b,c,d,e\r\nfoo,1.5,\"hello, world\",\"say \"\"hi\"\"\",\r\n",
+		},
+		{
+			Options: []WriterOption{
+				WithHeaders([]string{"a", "b", "c", "d", "e"}),
+				WithOutputFormatters([]string{"a:string:upper", "b:float:%.2f"}),
+			},
+			Want: "a,b,c,d,e\nFOO,1.50,\"hello, world\",\"say \"\"hi\"\"\",\n",
+		},
+	}
+	for i, d := range data {
+		var b strings.Builder
+		w, err := NewWriter(&b, d.Options...)
+		if err != nil {
+			t.Errorf("%d) fail to create writer: %s", i+1, err)
+			continue
+		}
+		if err := w.Write(row); err != nil {
+			t.Errorf("%d) fail to write row: %s", i+1, err)
+			continue
+		}
+		if err := w.Flush(); err != nil {
+			t.Errorf("%d) fail to flush writer: %s", i+1, err)
+			continue
+		}
+		if got := b.String(); got != d.Want {
+			t.Errorf("%d) wrong output: want %q, got %q", i+1, d.Want, got)
+		}
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	data := [][][]string{
+		{
+			{"name", "text"},
+			{"foo", "hello, world"},
+			{"bar", "line\nbreak"},
+			{"baz", "\"quoted\""},
+		},
+		{
+			{"name"},
+			{"foo"},
+			{""},
+			{"bar"},
+		},
+	}
+	for _, rows := range data {
+		for _, q := range []Quoting{QuoteMinimal, QuoteAll} {
+			var b strings.Builder
+			w, _ := NewWriter(&b, WithHeaders(rows[0]), WithQuoting(q))
+			if err := w.WriteAll(rows[1:]); err != nil {
+				t.Fatalf("fail to write rows: %s", err)
+			}
+			r, err := NewReader(strings.NewReader(b.String()), WithHeader())
+			if err != nil {
+				t.Fatalf("fail to create reader: %s", err)
+			}
+			if got, want := strings.Join(r.Headers(), ","), strings.Join(rows[0], ","); got != want {
+				t.Errorf("wrong headers: want %s, got %s", want, got)
+			}
+			for i := 1; ; i++ {
+				row, err := r.Next()
+				if err == io.EOF {
+					if i != len(rows) {
+						t.Errorf("wrong number of rows: want %d, got %d", len(rows), i)
+					}
+					break
+				}
+				if err != nil {
+					t.Fatalf("fail to read row: %s", err)
+				}
+				if strings.Join(row, "|") != strings.Join(rows[i], "|") {
+					t.Errorf("%d) wrong row: want %q, got %q", i, rows[i], row)
+				}
+			}
+		}
+	}
+}